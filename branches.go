@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// branchPattern matches branch names, either a glob or a regexp prefixed with "re:"
+type branchPattern struct {
+	raw  string
+	glob string
+	re   *regexp.Regexp
+}
+
+func newBranchPattern(pattern string) (branchPattern, error) {
+
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return branchPattern{}, fmt.Errorf("invalid branch regexp %q: %w", expr, err)
+		}
+		return branchPattern{raw: pattern, re: re}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return branchPattern{}, fmt.Errorf("invalid branch glob %q: %w", pattern, err)
+	}
+
+	return branchPattern{raw: pattern, glob: pattern}, nil
+}
+
+func (p branchPattern) match(branch string) bool {
+	if p.re != nil {
+		return p.re.MatchString(branch)
+	}
+	matched, _ := path.Match(p.glob, branch)
+	return matched
+}
+
+// Branch protection modes
+const (
+	ProtectionAll         = "all"
+	ProtectionProtected   = "protected"
+	ProtectionUnprotected = "unprotected"
+)
+
+// BranchSelector decides which branches are gitops branches the rollback operates on
+type BranchSelector struct {
+	prefix     string
+	include    []branchPattern
+	exclude    []branchPattern
+	ignore     []string
+	protection string
+}
+
+// NewBranchSelector creates a selector keeping the branches matching an include pattern, or starting with
+// the prefix if there is none, and dropping the branches matching an exclude pattern or in the ignore list.
+// The protection mode keeps all branches, or only the protected or unprotected ones.
+func NewBranchSelector(prefix string, include, exclude, ignore []string, protection string) (*BranchSelector, error) {
+
+	if protection == "" {
+		protection = ProtectionAll
+	}
+
+	if !slices.Contains([]string{ProtectionAll, ProtectionProtected, ProtectionUnprotected}, protection) {
+		return nil, fmt.Errorf("unknown protection mode %q, expected all, protected or unprotected", protection)
+	}
+
+	selector := &BranchSelector{
+		prefix:     prefix,
+		ignore:     ignore,
+		protection: protection,
+	}
+
+	for _, pattern := range include {
+		p, err := newBranchPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		selector.include = append(selector.include, p)
+	}
+
+	for _, pattern := range exclude {
+		p, err := newBranchPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		selector.exclude = append(selector.exclude, p)
+	}
+
+	return selector, nil
+}
+
+// Match reports whether a branch is selected by its name
+func (s *BranchSelector) Match(branch string) bool {
+	_, reason := s.exclusionReason(branch, nil)
+	return reason == ""
+}
+
+// exclusionReason returns why a branch is not selected, or an empty string if it is.
+// candidate reports whether the branch matched the prefix or an include pattern.
+// An unknown protection status never excludes a branch.
+func (s *BranchSelector) exclusionReason(branch string, protected *bool) (candidate bool, reason string) {
+
+	if len(s.include) > 0 {
+		if !slices.ContainsFunc(s.include, func(p branchPattern) bool { return p.match(branch) }) {
+			return false, "not matched by any include pattern"
+		}
+	} else if !strings.HasPrefix(branch, s.prefix) {
+		return false, fmt.Sprintf("doesn't have the prefix %s", s.prefix)
+	}
+
+	if slices.Contains(s.ignore, branch) {
+		return true, "in the ignore list"
+	}
+
+	for _, p := range s.exclude {
+		if p.match(branch) {
+			return true, fmt.Sprintf("matched by the exclude pattern %s", p.raw)
+		}
+	}
+
+	if protected != nil {
+		if s.protection == ProtectionProtected && !*protected {
+			return true, "not protected, protection mode is protected"
+		}
+		if s.protection == ProtectionUnprotected && *protected {
+			return true, "protected, protection mode is unprotected"
+		}
+	}
+
+	return true, ""
+}
+
+// Include returns the include patterns, or the prefix as a glob if there is none
+func (s *BranchSelector) Include() []string {
+
+	if len(s.include) == 0 {
+		return []string{s.prefix + "*"}
+	}
+
+	patterns := make([]string, len(s.include))
+	for i, p := range s.include {
+		patterns[i] = p.raw
+	}
+
+	return patterns
+}
+
+// Protection returns the protection mode
+func (s *BranchSelector) Protection() string {
+	return s.protection
+}
+
+// Exclude returns the exclude patterns followed by the ignored branches
+func (s *BranchSelector) Exclude() []string {
+
+	patterns := make([]string, 0, len(s.exclude)+len(s.ignore))
+	for _, p := range s.exclude {
+		patterns = append(patterns, p.raw)
+	}
+
+	return append(patterns, s.ignore...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBranchSelector(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		ignore  []string
+		branch  string
+		want    bool
+	}{
+		{name: "prefix", branch: "gitops/api", want: true},
+		{name: "prefix includes nested branches", branch: "gitops/api/prod", want: true},
+		{name: "other prefix", branch: "deploy/eu-west", want: false},
+		{name: "ignored", ignore: []string{"gitops/infra"}, branch: "gitops/infra", want: false},
+		{name: "include glob", include: []string{"gitops/api-*"}, branch: "gitops/api-prod", want: true},
+		{name: "include glob replaces the prefix", include: []string{"deploy/eu-*"}, branch: "deploy/eu-west", want: true},
+		{name: "not included", include: []string{"gitops/api-*"}, branch: "gitops/worker", want: false},
+		{name: "exclude glob", include: []string{"gitops/api-*"}, exclude: []string{"gitops/*-canary"}, branch: "gitops/api-canary", want: false},
+		{name: "include regexp", include: []string{"re:^deploy/(eu|us)-"}, branch: "deploy/us-east", want: true},
+		{name: "exclude regexp", exclude: []string{"re:-canary$"}, branch: "gitops/api-canary", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewBranchSelector("gitops/", tt.include, tt.exclude, tt.ignore, ProtectionAll)
+			if err != nil {
+				t.Fatalf("Failed to create branch selector: %v", err)
+			}
+
+			if got := selector.Match(tt.branch); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.branch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchSelectorInvalidPatterns(t *testing.T) {
+
+	if _, err := NewBranchSelector("gitops/", []string{"gitops/["}, nil, nil, ProtectionAll); err == nil {
+		t.Errorf("Expected an error for an invalid glob")
+	}
+
+	if _, err := NewBranchSelector("gitops/", nil, []string{"re:("}, nil, ProtectionAll); err == nil {
+		t.Errorf("Expected an error for an invalid regexp")
+	}
+}
+
+func TestBranchSelectorProtection(t *testing.T) {
+
+	protected, unprotected := true, false
+
+	tests := []struct {
+		mode      string
+		protected *bool
+		want      string
+	}{
+		{mode: ProtectionAll, protected: &protected, want: ""},
+		{mode: ProtectionAll, protected: &unprotected, want: ""},
+		{mode: ProtectionProtected, protected: &protected, want: ""},
+		{mode: ProtectionProtected, protected: &unprotected, want: "not protected, protection mode is protected"},
+		{mode: ProtectionUnprotected, protected: &protected, want: "protected, protection mode is unprotected"},
+		{mode: ProtectionUnprotected, protected: &unprotected, want: ""},
+		{mode: ProtectionProtected, protected: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		selector, err := NewBranchSelector("gitops/", nil, nil, nil, tt.mode)
+		if err != nil {
+			t.Fatalf("Failed to create branch selector: %v", err)
+		}
+
+		candidate, reason := selector.exclusionReason("gitops/api", tt.protected)
+		if !candidate || reason != tt.want {
+			t.Errorf("exclusionReason with mode %s and protected %v = %v, %q, want %q", tt.mode, protectionStatus(tt.protected), candidate, reason, tt.want)
+		}
+	}
+
+	if _, err := NewBranchSelector("gitops/", nil, nil, nil, "locked"); err == nil {
+		t.Errorf("Expected an error for an unknown protection mode")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// command is a subcommand of the CLI, each with its own flag set
+type command struct {
+	name    string
+	summary string
+	usage   string
+	run     func(fs *flag.FlagSet, args []string) error
+}
+
+// targetUsage lists the target selectors of the commands computing a rollback plan, exactly one is required
+const targetUsage = "(-desiredCommitHash=<sha> | -to-tag=<tag> | -to-pr=<number> | -to-release=<tag|latest> | -to-time=<time> | -bad-commit=<sha>)"
+
+var commands = []*command{
+	{
+		name:    "analyze",
+		summary: "Compute the rollback plan and print it without changing anything",
+		usage:   "analyze " + targetUsage + " [flags]",
+		run:     runAnalyze,
+	},
+	{
+		name:    "plan",
+		summary: "Compute the rollback plan and save it to a file for review",
+		usage:   "plan " + targetUsage + " -out=<file> [flags]",
+		run:     runPlan,
+	},
+	{
+		name:    "apply",
+		summary: "Apply a rollback plan saved by the plan command",
+		usage:   "apply -plan=<file> [-push | -deliver=<local|push|pr>] [-freeze -workflows=<workflows>] [-wait]",
+		run:     runApply,
+	},
+	{
+		name:    "rollback",
+		summary: "Compute the rollback plan and revert the gitops branches right away",
+		usage:   "rollback " + targetUsage + " [-push | -deliver=<local|push|pr>] [-freeze -workflows=<workflows>] [-wait] [flags]",
+		run:     runRollback,
+	},
+	{
+		name:    "roll-forward",
+		summary: "Undo a previous rollback, reverting its commits or restoring the paths to the deploy before it",
+		usage:   "roll-forward [-rollbackId=<id>] [-strategy=<revert|restore>] [-push | -deliver=<local|push|pr>] [flags]",
+		run:     runRollForward,
+	},
+	{
+		name:    "unfreeze",
+		summary: "Enable the deploy workflows disabled by the -freeze of a rollback again",
+		usage:   "unfreeze [-freezeState=<file>]",
+		run:     runUnfreeze,
+	},
+	{
+		name:    "status",
+		summary: "Show the master commit currently deployed on every gitops branch",
+		usage:   "status [flags]",
+		run:     runStatus,
+	},
+	{
+		name:    "graph",
+		summary: "Show the master commits with the gitops commits deploying them",
+		usage:   "graph [flags]",
+		run:     runGraph,
+	},
+	{
+		name:    "branches",
+		summary: "List the gitops branches the rollback operates on",
+		usage:   "branches [flags]",
+		run:     runBranches,
+	},
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// usage prints the list of commands
+func usage() {
+	fmt.Fprintf(os.Stderr, "\nUsage: %s <command> [flags]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nEnvironment variables:")
+	fmt.Fprintf(os.Stderr, "\n  GITHUB_TOKEN     GitHub personal access token (required with -source=github)")
+	fmt.Fprintf(os.Stderr, "\n")
+	fmt.Fprintf(os.Stderr, "\nExample: %s rollback -desiredCommitHash=f50d95b53a5d9fdb2a1039b6a86aa180ee1afb3d -owner=trivago -repo=hotel-search-web -path=manifests/api/prod -ignoreBranches=gitops/skip-branch,gitops/infra -since=1 -push=false\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// newFlagSet creates the flag set of a command with its help text
+func (cmd *command) newFlagSet() *flag.FlagSet {
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "\nUsage: %s %s\n\n%s\n\nFlags:\n", os.Args[0], cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// writeDocument writes v as json or yaml, or calls writeText for the text format
+func writeDocument(w io.Writer, format string, v any, writeText func(io.Writer) error) error {
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(v)
+	case "text":
+		return writeText(w)
+	default:
+		return fmt.Errorf("unknown output format %q, expected text, json or yaml", format)
+	}
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// analysis holds the gitops history read by the commands
+type analysis struct {
+	opts           *Options
+	start          time.Time
+	source         Source
+	matcher        *ReferenceMatcher
+	selector       *BranchSelector
+	repoDir        string
+	branches       []GitOpsBranch
+	excluded       []ExcludedBranch
+	window         Window
+	commitGraph    map[string]*HeadCommit
+	commitsHistory map[string][]GitOpsCommit
+}
+
+// newAnalysis opens the source of the history, the local source first clones the repository
+func newAnalysis(opts *Options, start time.Time) (*analysis, error) {
+
+	matcher, err := NewReferenceMatcher(opts.MessagePatterns, opts.SourceRepos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message patterns: %w", err)
+	}
+
+	selector, err := NewBranchSelector(opts.BranchPrefix, opts.IncludeBranches, opts.ExcludeBranches, opts.IgnoreBranches, opts.Protection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse branch patterns: %w", err)
+	}
+
+	a := &analysis{
+		opts:     opts,
+		start:    start,
+		matcher:  matcher,
+		selector: selector,
+	}
+
+	// The local source analyzes the same clone that is used for the rollback
+	if opts.Source == "local" {
+		a.repoDir, err = cloneRepository(opts.Owner, opts.Repo, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.source, err = newSource(opts.Source, opts.Owner, opts.Repo, a.repoDir)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to create %s source: %w", opts.Source, err)
+	}
+
+	return a, nil
+}
+
+// Close removes the clone of the repository, if any
+func (a *analysis) Close() {
+	if a.repoDir != "" {
+		os.RemoveAll(a.repoDir)
+	}
+}
+
+// clone returns the clone of the repository, cloning it if the source didn't already
+func (a *analysis) clone() (string, error) {
+
+	if a.repoDir != "" {
+		return a.repoDir, nil
+	}
+
+	repoDir, err := cloneRepository(a.opts.Owner, a.opts.Repo, a.start)
+	if err != nil {
+		return "", err
+	}
+	a.repoDir = repoDir
+
+	return repoDir, nil
+}
+
+// listBranches lists the gitops branches
+func (a *analysis) listBranches() error {
+
+	branches, excluded, err := listGitOpsBranches(a.source, a.selector)
+	if err != nil {
+		return fmt.Errorf("failed to list gitops branches: %w", err)
+	}
+	a.branches = branches
+	a.excluded = excluded
+
+	// Only the exclusions of branches matching the prefix or an include pattern are reported, not every branch of the repository
+	for _, branch := range candidateExclusions(excluded) {
+		log.Printf("Excluding branch %s: %s", branch.Branch, branch.Reason)
+	}
+
+	if a.opts.Source == "local" && a.selector.Protection() != ProtectionAll {
+		log.Printf("Branch protection is not known with the local source, protection mode %s is not applied", a.selector.Protection())
+	}
+
+	names := branchNames(branches)
+	slices.Sort(names)
+	log.Printf("Selected %d gitops branches: %s", len(names), strings.Join(names, ", "))
+
+	return nil
+}
+
+// buildGraph lists the gitops branches and links their history to the master commits
+func (a *analysis) buildGraph() error {
+
+	if err := a.listBranches(); err != nil {
+		return err
+	}
+
+	a.window = a.opts.Window
+	log.Printf("Reading the history %s", a.window)
+
+	masterCommits, err := a.listMasterCommits()
+	if err != nil {
+		return err
+	}
+
+	a.commitGraph, a.commitsHistory, err = generateCommitGraph(a.source, a.window, branchNames(a.branches), masterCommits, a.opts.Paths, a.matcher)
+	if err != nil {
+		return fmt.Errorf("failed to generate commit graph: %w", err)
+	}
+
+	return nil
+}
+
+// listMasterCommits lists the master commits of the current window
+func (a *analysis) listMasterCommits() (map[string]*HeadCommit, error) {
+
+	commits, err := a.source.ListCommitsSince(context.Background(), a.window, "master")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %w", err)
+	}
+
+	return processHeadCommits(commits), nil
+}
+
+// extendHistory widens the window until the target is in the master history and every gitops branch
+// has an anchor, up to the maximum lookback window. It returns the anchors that were found.
+func (a *analysis) extendHistory(target string) (*AnchorResult, error) {
+
+	widened := false
+	for {
+		if _, ok := a.commitGraph[target]; ok {
+			break
+		}
+		if err := a.widen(nil); err != nil {
+			return nil, fmt.Errorf("target commit %s not found in the master history %s: %w", target, a.window, err)
+		}
+		widened = true
+	}
+
+	// The gitops history has to cover the master history the target was found in
+	if widened {
+		if err := a.fetchBranches(branchNames(a.branches)); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		anchors, err := findRollbackCommits(a.commitGraph, a.commitsHistory, branchNames(a.branches), target, a.opts.Walk)
+		if err != nil {
+			return nil, err
+		}
+
+		pending := anchors.Unanchored()
+		if len(pending) == 0 {
+			return anchors, nil
+		}
+
+		if err := a.widen(pending); err != nil {
+			for _, branch := range anchors.NeverDeployed {
+				log.Printf("Giving up on branch %s: no deploy of any master commit in the history %s", branch, a.window)
+			}
+			for branch, oldest := range anchors.DeployedAfterTarget {
+				log.Printf("Giving up on branch %s: its oldest deploy in the history %s is of %s, newer than the target", branch, a.window, oldest.HeadCommit)
+			}
+			for branch, oldest := range anchors.DeployedNonAncestors {
+				log.Printf("Giving up on branch %s: it deployed only non-ancestors of the target in the history %s, the oldest is %s", branch, a.window, oldest.HeadCommit)
+			}
+			return anchors, nil
+		}
+	}
+}
+
+// widen doubles the window and fetches the master history again, then the history of the pending gitops branches.
+// The history of the other branches is kept and linked to the new master history.
+func (a *analysis) widen(pending []string) error {
+
+	window, ok := a.window.widen(time.Now(), a.opts.MaxWindow)
+	if !ok {
+		return fmt.Errorf("maximum lookback window %s reached", a.opts.MaxWindow.Spec)
+	}
+	a.window = window
+
+	if len(pending) > 0 {
+		log.Printf("Widening the lookback window, reading the history %s for %d branches without anchor: %s", a.window, len(pending), strings.Join(pending, ", "))
+	} else {
+		log.Printf("Widening the lookback window, reading the history %s", a.window)
+	}
+
+	masterCommits, err := a.listMasterCommits()
+	if err != nil {
+		return err
+	}
+
+	for branch, branchCommits := range a.commitsHistory {
+		if !slices.Contains(pending, branch) {
+			linkGitOpsCommits(masterCommits, branch, branchCommits, a.matcher)
+		}
+	}
+	a.commitGraph = masterCommits
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return a.fetchBranches(pending)
+}
+
+// fetchBranches fetches the history of gitops branches in the current window and links it to the master history
+func (a *analysis) fetchBranches(branches []string) error {
+
+	commitGraph, commitsHistory, err := generateCommitGraph(a.source, a.window, branches, a.commitGraph, a.opts.Paths, a.matcher)
+	if err != nil {
+		return fmt.Errorf("failed to generate commit graph: %w", err)
+	}
+
+	for branch, branchCommits := range commitsHistory {
+		a.commitsHistory[branch] = branchCommits
+	}
+	a.commitGraph = commitGraph
+
+	return nil
+}
+
+// resolveTarget resolves the target selector to a master commit, a time is resolved with the commits graph
+// and widens the window until a deploy at or before that time is found
+func (a *analysis) resolveTarget() (string, error) {
+
+	ctx := context.Background()
+
+	switch {
+	case a.opts.ToTag != "":
+		return a.source.ResolveCommit(ctx, "tags/"+a.opts.ToTag)
+	case a.opts.ToPR != 0:
+		return a.source.PullRequestMergeCommit(ctx, a.opts.ToPR)
+	case a.opts.ToRelease != "":
+		return a.source.ReleaseCommit(ctx, a.opts.ToRelease)
+	case a.opts.ToTime != "":
+		before, err := parseTargetTime(a.opts.ToTime)
+		if err != nil {
+			return "", err
+		}
+		for {
+			if sha, ok := findDeployedBefore(a.commitGraph, before); ok {
+				return sha, nil
+			}
+			if err := a.widen(nil); err != nil {
+				return "", fmt.Errorf("no deploy at or before %s in the history %s: %w", a.opts.ToTime, a.window, err)
+			}
+			if err := a.fetchBranches(branchNames(a.branches)); err != nil {
+				return "", err
+			}
+		}
+	case a.opts.BadCommit != "":
+		return a.resolveBadCommitParent()
+	default:
+		// A full SHA is resolved too, so that a commit unknown to the repository fails before the analysis
+		return a.source.ResolveCommit(ctx, a.opts.Target)
+	}
+}
+
+// resolveBadCommitParent returns the first parent of the bad commit, the newest deploys of it or its ancestors
+// are the newest deploys that don't include the bad commit. The window is widened until the bad commit is found.
+func (a *analysis) resolveBadCommitParent() (string, error) {
+
+	bad := a.opts.BadCommit
+	if len(bad) < 40 {
+		var err error
+		if bad, err = a.source.ResolveCommit(context.Background(), bad); err != nil {
+			return "", err
+		}
+	}
+
+	for {
+		if commit, ok := a.commitGraph[bad]; ok {
+			if len(commit.Parents) == 0 {
+				return "", fmt.Errorf("bad commit %s is a root commit, there is nothing to roll back to", bad)
+			}
+			return commit.Parents[0], nil
+		}
+		if err := a.widen(nil); err != nil {
+			return "", fmt.Errorf("bad commit %s not found in the master history %s: %w", bad, a.window, err)
+		}
+	}
+}
+
+// plan computes the rollback plan to the target commit
+func (a *analysis) plan() (*Plan, error) {
+
+	if err := a.buildGraph(); err != nil {
+		return nil, err
+	}
+
+	target, err := a.resolveTarget()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the target %s: %w", a.opts.targetSelector(), err)
+	}
+	log.Printf("Resolved the target %s to %s", a.opts.targetSelector(), target)
+
+	anchors, err := a.extendHistory(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find rollback commits: %w", err)
+	}
+
+	log.Printf("Finding commits after the gitops commit related to the desired commit")
+	commitsAfterRollback, err := findCommitsAfterRollback(anchors.Anchors, a.commitsHistory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find commits after the gitops commit related to the desired commit: %w", err)
+	}
+
+	plan := buildPlan(a.opts.Owner, a.opts.Repo, a.opts.Paths, a.window, a.commitGraph[target], a.branches, anchors, commitsAfterRollback)
+	plan.Selection = PlanSelection{
+		Include:    a.selector.Include(),
+		Exclude:    a.selector.Exclude(),
+		Protection: a.selector.Protection(),
+		Branches:   branchNames(a.branches),
+		Excluded:   candidateExclusions(a.excluded),
+	}
+	slices.Sort(plan.Selection.Branches)
+	plan.Target.Walk = a.opts.Walk
+	plan.Target.Selector = a.opts.targetSelector()
+	plan.Strategy = a.opts.Strategy
+	plan.Scope = a.opts.RevertScope
+
+	// Both a path-scoped revert and a restore leave the files outside of the paths alone
+	if plan.Scope == ScopePath || plan.Strategy == StrategyRestore {
+		if err := a.findLeftAlone(plan); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Number of branches to process: %d", len(plan.Branches))
+
+	return plan, nil
+}
+
+// findLeftAlone records the files outside of the analyzed paths changed by the commits to revert,
+// a path-scoped revert leaves them alone
+func (a *analysis) findLeftAlone(plan *Plan) error {
+
+	for _, branch := range plan.Branches {
+		for i, commit := range branch.Commits {
+			files, err := a.source.ListCommitFiles(context.Background(), commit.SHA)
+			if err != nil {
+				return fmt.Errorf("failed to list the files of commit %s: %w", commit.SHA, err)
+			}
+
+			branch.Commits[i].LeftAlone = outsidePaths(files, commit.Paths)
+			if len(branch.Commits[i].LeftAlone) > 0 {
+				log.Printf("Leaving alone the changes of commit %s on branch %s outside of %s: %s", commit.SHA, branch.Branch, strings.Join(commit.Paths, ", "), strings.Join(branch.Commits[i].LeftAlone, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// rollForwardPlan computes the plan undoing a rollback, the newest rollback not rolled forward yet if no ID is given
+func (a *analysis) rollForwardPlan(rollbackID string) (*Plan, error) {
+
+	if err := a.buildGraph(); err != nil {
+		return nil, err
+	}
+
+	if rollbackID == "" {
+		rollbackID = latestRollback(a.commitsHistory)
+		if rollbackID == "" {
+			return nil, fmt.Errorf("no rollback to roll forward found in the history %s", a.window)
+		}
+		log.Printf("Rolling forward the latest rollback %s", rollbackID)
+	}
+
+	plan := buildRollForwardPlan(a.opts.Owner, a.opts.Repo, a.opts.Paths, a.window, rollbackID, a.branches, a.commitGraph, a.commitsHistory, a.matcher)
+	plan.Selection = PlanSelection{
+		Include:    a.selector.Include(),
+		Exclude:    a.selector.Exclude(),
+		Protection: a.selector.Protection(),
+		Branches:   branchNames(a.branches),
+		Excluded:   candidateExclusions(a.excluded),
+	}
+	slices.Sort(plan.Selection.Branches)
+	plan.Strategy = a.opts.Strategy
+	plan.Scope = a.opts.RevertScope
+
+	if plan.Scope == ScopePath || plan.Strategy == StrategyRestore {
+		if err := a.findLeftAlone(plan); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Number of branches to roll forward: %d", len(plan.Branches))
+
+	return plan, nil
+}
+
+// registerAnalysisFlags registers the flags of the commands computing a rollback plan
+func registerAnalysisFlags(fs *flag.FlagSet, opts *Options) {
+	opts.registerRepoFlags(fs)
+	opts.registerBranchFlags(fs)
+	opts.registerHistoryFlags(fs)
+	opts.registerTargetFlags(fs)
+	opts.registerRevertFlags(fs)
+	opts.registerOutputFlags(fs)
+}
+
+func runAnalyze(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	registerAnalysisFlags(fs, opts)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	if err := opts.validateTarget(); err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	plan, err := a.plan()
+	if err != nil {
+		return err
+	}
+
+	return writePlan(os.Stdout, plan, opts.Output)
+}
+
+// runPlan computes the rollback plan and saves it so that it can be reviewed and applied later
+func runPlan(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	registerAnalysisFlags(fs, opts)
+	outFlag := fs.String("out", "rollback-plan.json", "The File to save the rollback plan to, as YAML if it ends with .yaml or .yml, JSON otherwise")
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	if err := opts.validateTarget(); err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	plan, err := a.plan()
+	if err != nil {
+		return err
+	}
+
+	if err := writePlan(os.Stdout, plan, opts.Output); err != nil {
+		return fmt.Errorf("failed to write rollback plan: %w", err)
+	}
+
+	if err := savePlan(*outFlag, plan); err != nil {
+		return fmt.Errorf("failed to save rollback plan: %w", err)
+	}
+
+	log.Printf("Rollback plan %s saved to %s in %v", plan.ID, *outFlag, time.Since(start))
+	return nil
+}
+
+// runApply executes exactly the commits of a saved rollback plan
+func runApply(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	planFlag := fs.String("plan", "", "The rollback plan file written by the plan command")
+	opts.registerPushFlags(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	if *planFlag == "" {
+		return usageError(fmt.Errorf("the -plan flag is required"))
+	}
+
+	plan, err := loadPlan(*planFlag)
+	if err != nil {
+		return fmt.Errorf("failed to load rollback plan: %w", err)
+	}
+
+	delivery, err := newDelivery(opts, plan.Owner, plan.Repo)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Applying rollback plan %s to %s/%s, target %s", plan.ID, plan.Owner, plan.Repo, plan.Target.SHA)
+
+	repoDir, err := cloneRepository(plan.Owner, plan.Repo, start)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(repoDir)
+
+	// Refuse the whole plan if any branch moved since it was computed
+	if err := verifyBranchHeads(repoDir, plan); err != nil {
+		return fmt.Errorf("refusing to apply rollback plan %s: %w", plan.ID, err)
+	}
+
+	log.Printf("------------------- START ROLLBACK -------------------")
+	results := executePlan(plan, repoDir, opts.OnConflict, delivery)
+	log.Printf("------------------- END ROLLBACK -------------------")
+	if err := writeSummary(os.Stderr, results); err != nil {
+		return err
+	}
+	log.Printf("Rollback plan %s applied in %v", plan.ID, time.Since(start))
+
+	return resultsError(results)
+}
+
+// runRollback computes the rollback plan and reverts the gitops branches right away
+func runRollback(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	registerAnalysisFlags(fs, opts)
+	opts.registerPushFlags(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	if err := opts.validateTarget(); err != nil {
+		return err
+	}
+
+	delivery, err := newDelivery(opts, opts.Owner, opts.Repo)
+	if err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	plan, err := a.plan()
+	if err != nil {
+		return err
+	}
+
+	if err := writePlan(os.Stdout, plan, opts.Output); err != nil {
+		return fmt.Errorf("failed to write rollback plan: %w", err)
+	}
+
+	//  Newest to oldest
+	log.Printf("------------------- START ROLLBACK -------------------")
+
+	repoDir, err := a.clone()
+	if err != nil {
+		return err
+	}
+
+	results := executePlan(plan, repoDir, opts.OnConflict, delivery)
+
+	log.Printf("------------------- END ROLLBACK -------------------")
+	if err := writeSummary(os.Stderr, results); err != nil {
+		return err
+	}
+	log.Printf("Rollback completed in %v", time.Since(start))
+
+	return resultsError(results)
+}
+
+// runRollForward undoes a previous rollback, reverting its commits or restoring the paths to the deploy before it
+func runRollForward(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	opts.registerRepoFlags(fs)
+	opts.registerBranchFlags(fs)
+	opts.registerHistoryFlags(fs)
+	opts.registerRevertFlags(fs)
+	opts.registerOutputFlags(fs)
+	opts.registerPushFlags(fs)
+	rollbackIDFlag := fs.String("rollbackId", "", "The ID of the rollback plan to roll forward, the latest rollback not rolled forward yet if empty")
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	delivery, err := newDelivery(opts, opts.Owner, opts.Repo)
+	if err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	plan, err := a.rollForwardPlan(*rollbackIDFlag)
+	if err != nil {
+		return err
+	}
+
+	if err := writePlan(os.Stdout, plan, opts.Output); err != nil {
+		return fmt.Errorf("failed to write roll-forward plan: %w", err)
+	}
+
+	log.Printf("------------------- START ROLL FORWARD -------------------")
+
+	repoDir, err := a.clone()
+	if err != nil {
+		return err
+	}
+
+	results := executePlan(plan, repoDir, opts.OnConflict, delivery)
+
+	log.Printf("------------------- END ROLL FORWARD -------------------")
+	if err := writeSummary(os.Stderr, results); err != nil {
+		return err
+	}
+	log.Printf("Roll forward of rollback %s completed in %v", plan.RollForward, time.Since(start))
+
+	return resultsError(results)
+}
+
+// runUnfreeze enables the deploy workflows disabled by -freeze again, the cancelled runs are not run again
+func runUnfreeze(fs *flag.FlagSet, args []string) error {
+
+	opts := defaultOptions()
+	opts.registerFreezeStateFlag(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	state, err := loadFreezeState(opts.FreezeState)
+	if err != nil {
+		return fmt.Errorf("failed to load freeze state: %w", err)
+	}
+
+	client, err := NewGithubClient(state.Owner, state.Repo)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Unfreezing the deploys of %s/%s frozen by %s", state.Owner, state.Repo, strings.Join(state.Plans, ", "))
+	for _, run := range state.CancelledRuns {
+		log.Printf("Run %s of branch %s was cancelled by the freeze and is not run again", run.URL, run.Branch)
+	}
+
+	failed, unfreezeErr := unfreezeDeploys(context.Background(), client, state)
+	if unfreezeErr == nil {
+		log.Printf("Every workflow disabled by the freeze is enabled again, removing %s", opts.FreezeState)
+		return os.Remove(opts.FreezeState)
+	}
+
+	// Keep the workflows that are still disabled for the next unfreeze
+	state.Workflows = failed
+	if err := saveFreezeState(opts.FreezeState, state); err != nil {
+		return errors.Join(unfreezeErr, fmt.Errorf("failed to save freeze state: %w", err))
+	}
+
+	return unfreezeErr
+}
+
+// runStatus shows the master commit currently deployed on every gitops branch
+func runStatus(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	opts.registerRepoFlags(fs)
+	opts.registerBranchFlags(fs)
+	opts.registerHistoryFlags(fs)
+	opts.registerOutputFlags(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.buildGraph(); err != nil {
+		return err
+	}
+
+	statuses := findDeployedCommits(a.branches, a.commitGraph, a.commitsHistory, a.matcher)
+	slices.SortFunc(statuses, func(x, y BranchStatus) int { return strings.Compare(x.Branch, y.Branch) })
+
+	return writeDocument(os.Stdout, opts.Output, statuses, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "BRANCH\tHEAD\tDEPLOYED COMMIT\tDEPLOYED AT\n")
+		for _, status := range statuses {
+			deployedCommit, deployedAt := "-", "-"
+			if status.DeployedCommit != "" {
+				deployedCommit = status.DeployedCommit
+				deployedAt = status.DeployedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%.12s\t%s\t%s\n", status.Branch, status.Head, deployedCommit, deployedAt)
+		}
+		return tw.Flush()
+	})
+}
+
+// runGraph shows the master commits with the gitops commits deploying them
+func runGraph(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	opts.registerRepoFlags(fs)
+	opts.registerBranchFlags(fs)
+	opts.registerHistoryFlags(fs)
+	opts.registerOutputFlags(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.buildGraph(); err != nil {
+		return err
+	}
+
+	// Newest to oldest
+	commits := make([]*HeadCommit, 0, len(a.commitGraph))
+	for _, commit := range a.commitGraph {
+		commits = append(commits, commit)
+	}
+	slices.SortFunc(commits, func(x, y *HeadCommit) int { return y.Date.Compare(x.Date) })
+
+	return writeDocument(os.Stdout, opts.Output, commits, func(w io.Writer) error {
+		for _, commit := range commits {
+			fmt.Fprintf(w, "Commit: %s\n", commit.SHA)
+			fmt.Fprintf(w, "Parents: %s\n", strings.Join(commit.Parents, ", "))
+			fmt.Fprintf(w, "Date: %v\n", commit.Date)
+
+			branches := make([]string, 0, len(commit.GitOpsCommits))
+			for branch := range commit.GitOpsCommits {
+				branches = append(branches, branch)
+			}
+			slices.Sort(branches)
+			for _, branch := range branches {
+				fmt.Fprintf(w, "  %s: %s\n", branch, commit.GitOpsCommits[branch].SHA)
+			}
+			fmt.Fprintf(w, "--------------------------------\n")
+		}
+		return nil
+	})
+}
+
+// runBranches lists the gitops branches the rollback operates on
+func runBranches(fs *flag.FlagSet, args []string) error {
+
+	start := time.Now()
+
+	opts := defaultOptions()
+	opts.registerRepoFlags(fs)
+	opts.registerBranchFlags(fs)
+	opts.registerOutputFlags(fs)
+	if err := loadOptions(fs, args, opts); err != nil {
+		return err
+	}
+
+	a, err := newAnalysis(opts, start)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.listBranches(); err != nil {
+		return err
+	}
+
+	branches := slices.Clone(a.branches)
+	slices.SortFunc(branches, func(x, y GitOpsBranch) int { return strings.Compare(x.Name, y.Name) })
+
+	listing := struct {
+		Branches []GitOpsBranch   `json:"branches" yaml:"branches"`
+		Excluded []ExcludedBranch `json:"excluded" yaml:"excluded"`
+	}{
+		Branches: branches,
+		Excluded: candidateExclusions(a.excluded),
+	}
+
+	return writeDocument(os.Stdout, opts.Output, listing, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "BRANCH\tHEAD\tPROTECTED\tREQUIRED CHECKS\n")
+		for _, branch := range listing.Branches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", branch.Name, branch.Head, protectionStatus(branch.Protected), strings.Join(branch.RequiredChecks, ","))
+		}
+		if len(listing.Excluded) > 0 {
+			fmt.Fprintf(tw, "\nEXCLUDED BRANCH\tREASON\n")
+			for _, branch := range listing.Excluded {
+				fmt.Fprintf(tw, "%s\t%s\n", branch.Branch, branch.Reason)
+			}
+		}
+		return tw.Flush()
+	})
+}
+
+// candidateExclusions returns the excluded branches that matched the prefix or include patterns,
+// the other branches of the repository are only reported in the logs
+func candidateExclusions(excluded []ExcludedBranch) []ExcludedBranch {
+
+	candidates := make([]ExcludedBranch, 0)
+	for _, branch := range excluded {
+		if branch.candidate {
+			candidates = append(candidates, branch)
+		}
+	}
+	slices.SortFunc(candidates, func(x, y ExcludedBranch) int { return strings.Compare(x.Branch, y.Branch) })
+
+	return candidates
+}
+
+// protectionStatus describes whether a branch is protected
+func protectionStatus(protected *bool) string {
+	switch {
+	case protected == nil:
+		return "unknown"
+	case *protected:
+		return "yes"
+	default:
+		return "no"
+	}
+}
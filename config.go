@@ -0,0 +1,580 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the repository-local config file, ~/.config/gitops-rollback/config.yaml is the fallback
+const configFileName = ".gitops-rollback.yaml"
+
+// Options holds the settings shared by the commands, each command only registers the flags it needs
+type Options struct {
+	Owner           string
+	Repo            string
+	Source          string
+	Paths           []string
+	BranchPrefix    string
+	IncludeBranches []string
+	ExcludeBranches []string
+	IgnoreBranches  []string
+	Protection      string
+	Since           string
+	Window          Window
+	MaxLookback     string
+	MaxWindow       Window
+	MessagePatterns []string
+	SourceRepos     []string
+	Target          string
+	ToTag           string
+	ToPR            int
+	ToRelease       string
+	ToTime          string
+	BadCommit       string
+	Walk            string
+	RevertScope     string
+	Strategy        string
+	Output          string
+	Push            bool
+	Deliver         string
+	Reviewers       []string
+	Labels          []string
+	AutoMerge       bool
+	OnConflict      string
+	Atomic          bool
+	Freeze          bool
+	Workflows       []string
+	FreezeState     string
+	Wait            bool
+	WaitTimeout     string
+	DeployTimeout   time.Duration
+}
+
+// defaultOptions returns the options used when no flag is given
+func defaultOptions() *Options {
+	return &Options{
+		Owner:          "trivago",
+		Repo:           "hsw-fork",
+		Source:         "github",
+		Paths:          []string{"manifests/api/prod"},
+		BranchPrefix:   "gitops/",
+		IgnoreBranches: []string{"gitops/sink", "gitops/infra", "gitops/stage", "gitops/seo-indexation", "gitops/member-data"},
+		Protection:     ProtectionAll,
+		Since:          "1",
+		Walk:           WalkFirstParent,
+		RevertScope:    ScopeCommit,
+		Strategy:       StrategyRevert,
+		Output:         "text",
+		OnConflict:     OnConflictSkip,
+		FreezeState:    "rollback-freeze.json",
+		WaitTimeout:    "30m",
+	}
+}
+
+// registerRepoFlags registers the flags selecting the repository and where its history is read from
+func (o *Options) registerRepoFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Owner, "owner", o.Owner, "The Owner of the GitHub repository")
+	fs.StringVar(&o.Repo, "repo", o.Repo, "The Name of the GitHub repository")
+	fs.StringVar(&o.Source, "source", o.Source, "The Source of the commit history, either github (REST API) or local (computed from a clone of the repository)")
+}
+
+// registerBranchFlags registers the flags selecting the gitops branches
+func (o *Options) registerBranchFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.BranchPrefix, "branchPrefix", o.BranchPrefix, "The Prefix of the gitops branches, used when no -includeBranches pattern is given")
+	fs.Var((*stringListFlag)(&o.IncludeBranches), "includeBranches", "The Pattern of the gitops branches to include, a glob (gitops/api-*) or a regexp prefixed with re: (re:^deploy/eu-.*$). Can be repeated")
+	fs.Var((*stringListFlag)(&o.ExcludeBranches), "excludeBranches", "The Pattern of the gitops branches to exclude, a glob (gitops/*-canary) or a regexp prefixed with re:. Can be repeated")
+	fs.Var((*commaListFlag)(&o.IgnoreBranches), "ignoreBranches", "The Comma-separated list of gitops branches to ignore")
+	fs.StringVar(&o.Protection, "protection", o.Protection, "The Branch protection mode, either all, protected (only protected branches) or unprotected (only unprotected branches)")
+}
+
+// registerHistoryFlags registers the flags controlling how the gitops history is analyzed
+func (o *Options) registerHistoryFlags(fs *flag.FlagSet) {
+	fs.Var(&replaceListFlag{values: &o.Paths}, "path", "The Path within the gitops branches to analyze, a directory or a glob (manifests/*/prod). Can be repeated")
+	fs.StringVar(&o.Since, "since", o.Since, "The Lookback window of the history: a number of months (1), a duration (72h), a date (2026-10-01) or RFC3339 timestamp, or a number of commits per branch (300commits)")
+	fs.StringVar(&o.MaxLookback, "maxLookback", o.MaxLookback, "The Maximum lookback window the history is extended to when the target or an anchor is not found, in the same form as -since. Defaults to 12 months, or 10 times the commits of -since")
+	fs.Var((*stringListFlag)(&o.MessagePatterns), "messagePattern", "The Pattern linking a gitops commit message to a master commit, either a preset (repo-sha, trailer, url) or a regexp with a named group \"sha\". Can be repeated")
+	fs.Var((*commaListFlag)(&o.SourceRepos), "sourceRepos", "The Comma-separated list of owner/repo prefixes accepted as the source of a gitops commit, all if empty")
+}
+
+// registerTargetFlags registers the flags selecting the master commit to roll back to
+func (o *Options) registerTargetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Target, "desiredCommitHash", o.Target, "The Desired Commit Hash to revert gitops branches to its state, full or short")
+	fs.StringVar(&o.ToTag, "to-tag", o.ToTag, "The Tag of the master commit to revert gitops branches to")
+	fs.IntVar(&o.ToPR, "to-pr", o.ToPR, "The Number of the pull request whose merge commit gitops branches are reverted to")
+	fs.StringVar(&o.ToRelease, "to-release", o.ToRelease, "The Release (its tag, or latest) whose commit gitops branches are reverted to")
+	fs.StringVar(&o.ToTime, "to-time", o.ToTime, "The Time (RFC3339, 2026-10-15T14:05Z) whose latest deployed master commit gitops branches are reverted to")
+	fs.StringVar(&o.BadCommit, "bad-commit", o.BadCommit, "The Bad master commit, full or short, gitops branches are reverted to the newest deploy that doesn't include it")
+	fs.StringVar(&o.Walk, "walk", o.Walk, "The Walk of the master history to find the deployed ancestors of the target, either first-parent (the mainline only) or full (also the branches merged into it)")
+}
+
+// registerRevertFlags registers the flags controlling how the gitops commits are reverted
+func (o *Options) registerRevertFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Strategy, "strategy", o.Strategy, "The Strategy of the rollback of a gitops branch, either revert (revert the commits after the anchor) or restore (restore -path to its state at the anchor in a single commit)")
+	fs.StringVar(&o.RevertScope, "revertScope", o.RevertScope, "The Scope of the revert of a gitops commit, either commit (the whole commit) or path (only its changes under -path, its other files are left alone)")
+}
+
+// registerOutputFlags registers the flags controlling what is written to stdout
+func (o *Options) registerOutputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Output, "output", o.Output, "The Format written to stdout, either text, json or yaml")
+}
+
+// registerPushFlags registers the flags controlling how the rollback is executed and delivered
+func (o *Options) registerPushFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.OnConflict, "onConflict", o.OnConflict, "The Handling of a branch whose revert conflicts, the revert is aborted and the branch either skipped (skip) or retried with the restore strategy (restore)")
+	fs.BoolVar(&o.Push, "push", o.Push, "if true, it will push the changes to the remote repository. Otherwise, it will just commit the changes. Used when -deliver is not given")
+	fs.StringVar(&o.Deliver, "deliver", o.Deliver, "The Delivery of the rollback commits, either local (keep them in the clone), push (push them to the gitops branches) or pr (push them to rollback/<branch>/<plan id> and open a pull request into the gitops branch)")
+	fs.Var((*commaListFlag)(&o.Reviewers), "reviewers", "The Comma-separated list of users, or org/team teams, requested to review the pull requests of -deliver=pr")
+	fs.Var((*commaListFlag)(&o.Labels), "labels", "The Comma-separated list of labels added to the pull requests of -deliver=pr")
+	fs.BoolVar(&o.Atomic, "atomic", o.Atomic, "if true, every branch is rolled back before any is delivered, then all are pushed in a single atomic push. If a branch fails or the push is refused, nothing is delivered and the branches are reset in the clone")
+	fs.BoolVar(&o.AutoMerge, "autoMerge", o.AutoMerge, "if true, auto-merge is enabled on the pull requests of -deliver=pr")
+	fs.BoolVar(&o.Freeze, "freeze", o.Freeze, "if true, the -workflows are disabled and the queued and in progress runs of the branches to roll back are cancelled before delivering, unfreeze enables the workflows again")
+	fs.Var((*commaListFlag)(&o.Workflows), "workflows", "The Comma-separated list of deploy workflows disabled by -freeze, by file name (deploy.yml) or ID")
+	o.registerFreezeStateFlag(fs)
+	fs.BoolVar(&o.Wait, "wait", o.Wait, "if true, the workflow runs of the pushed rollback commits are waited for, and the run fails if any deploy failed. -deliver=push only")
+	fs.StringVar(&o.WaitTimeout, "waitTimeout", o.WaitTimeout, "The maximum Duration to wait for the workflow runs of -wait, a deploy still running after it fails")
+}
+
+// registerFreezeStateFlag registers the flag of the file recording what the deploy freeze changed
+func (o *Options) registerFreezeStateFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.FreezeState, "freezeState", o.FreezeState, "The File recording the workflows disabled and the runs cancelled by -freeze, as YAML if it ends with .yaml or .yml, JSON otherwise")
+}
+
+// shaPattern matches a full or short commit SHA
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// validateTarget checks that exactly one target selector is given and that it is well-formed
+func (o *Options) validateTarget() error {
+
+	selectors := make([]string, 0)
+	if o.Target != "" {
+		selectors = append(selectors, "-desiredCommitHash")
+	}
+	if o.ToTag != "" {
+		selectors = append(selectors, "-to-tag")
+	}
+	if o.ToPR != 0 {
+		selectors = append(selectors, "-to-pr")
+	}
+	if o.ToRelease != "" {
+		selectors = append(selectors, "-to-release")
+	}
+	if o.ToTime != "" {
+		selectors = append(selectors, "-to-time")
+	}
+	if o.BadCommit != "" {
+		selectors = append(selectors, "-bad-commit")
+	}
+
+	switch len(selectors) {
+	case 0:
+		return usageError(fmt.Errorf("a target is required, one of -desiredCommitHash, -to-tag, -to-pr, -to-release, -to-time or -bad-commit"))
+	case 1:
+	default:
+		return usageError(fmt.Errorf("only one target can be given, got %s", strings.Join(selectors, " and ")))
+	}
+
+	if o.Target != "" && !shaPattern.MatchString(o.Target) {
+		return usageError(fmt.Errorf("invalid -desiredCommitHash %q, expected 7 to 40 lowercase hexadecimal characters", o.Target))
+	}
+
+	if o.BadCommit != "" && !shaPattern.MatchString(o.BadCommit) {
+		return usageError(fmt.Errorf("invalid -bad-commit %q, expected 7 to 40 lowercase hexadecimal characters", o.BadCommit))
+	}
+
+	if o.ToPR < 0 {
+		return usageError(fmt.Errorf("invalid -to-pr %d, expected a pull request number", o.ToPR))
+	}
+
+	if o.ToTime != "" {
+		if _, err := parseTargetTime(o.ToTime); err != nil {
+			return usageError(err)
+		}
+	}
+
+	return nil
+}
+
+// targetSelector describes the target selector that was given
+func (o *Options) targetSelector() string {
+
+	switch {
+	case o.ToTag != "":
+		return "tag " + o.ToTag
+	case o.ToPR != 0:
+		return fmt.Sprintf("pull request #%d", o.ToPR)
+	case o.ToRelease != "":
+		return "release " + o.ToRelease
+	case o.ToTime != "":
+		return "deployed at " + o.ToTime
+	case o.BadCommit != "":
+		return "parent of bad commit " + o.BadCommit
+	default:
+		return "commit " + o.Target
+	}
+}
+
+// parseTargetTime parses the time of -to-time
+func parseTargetTime(value string) (time.Time, error) {
+
+	for _, layout := range windowTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid -to-time %q, expected an RFC3339 timestamp (2026-10-15T14:05Z) or a date", value)
+}
+
+// loadOptions parses the command line of a command, applies the selected config profile
+// to the options whose flag wasn't given and validates the resulting options
+func loadOptions(fs *flag.FlagSet, args []string, opts *Options) error {
+
+	configFlag := fs.String("config", "", "The Config file, defaults to "+configFileName+" in the current directory or ~/.config/gitops-rollback/config.yaml")
+	profileFlag := fs.String("profile", "", "The Profile of the config file to use, defaults to its defaultProfile")
+
+	// The flag package reports -h as an error, it isn't a wrong usage
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return err
+	} else if err != nil {
+		return usageError(err)
+	}
+
+	if fs.NArg() > 0 {
+		return usageError(fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+	}
+
+	profile, err := loadProfile(*configFlag, *profileFlag)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if profile != nil {
+		profile.apply(opts, set)
+	}
+
+	if !slices.Contains([]string{"github", "local"}, opts.Source) {
+		return usageError(fmt.Errorf("unknown source %q, expected github or local", opts.Source))
+	}
+
+	if !slices.Contains([]string{"", ProtectionAll, ProtectionProtected, ProtectionUnprotected}, opts.Protection) {
+		return usageError(fmt.Errorf("unknown protection mode %q, expected all, protected or unprotected", opts.Protection))
+	}
+
+	if !slices.Contains([]string{WalkFirstParent, WalkFull}, opts.Walk) {
+		return usageError(fmt.Errorf("unknown walk %q, expected first-parent or full", opts.Walk))
+	}
+
+	if !slices.Contains([]string{StrategyRevert, StrategyRestore}, opts.Strategy) {
+		return usageError(fmt.Errorf("unknown strategy %q, expected revert or restore", opts.Strategy))
+	}
+
+	if !slices.Contains([]string{ScopeCommit, ScopePath}, opts.RevertScope) {
+		return usageError(fmt.Errorf("unknown revert scope %q, expected commit or path", opts.RevertScope))
+	}
+
+	if !slices.Contains([]string{"text", "json", "yaml"}, opts.Output) {
+		return usageError(fmt.Errorf("unknown output format %q, expected text, json or yaml", opts.Output))
+	}
+
+	// -push selects the delivery when -deliver is not given, a -push flag overrides the delivery of the profile
+	if set["push"] && !set["deliver"] {
+		opts.Deliver = ""
+	}
+	if opts.Deliver == "" {
+		opts.Deliver = DeliverLocal
+		if opts.Push {
+			opts.Deliver = DeliverPush
+		}
+	}
+
+	if !slices.Contains([]string{OnConflictSkip, OnConflictRestore}, opts.OnConflict) {
+		return usageError(fmt.Errorf("unknown conflict handling %q, expected skip or restore", opts.OnConflict))
+	}
+
+	if !slices.Contains([]string{DeliverLocal, DeliverPush, DeliverPR}, opts.Deliver) {
+		return usageError(fmt.Errorf("unknown delivery %q, expected local, push or pr", opts.Deliver))
+	}
+
+	opts.DeployTimeout, err = time.ParseDuration(opts.WaitTimeout)
+	if err != nil || opts.DeployTimeout <= 0 {
+		return usageError(fmt.Errorf("invalid -waitTimeout %q, expected a positive duration (30m)", opts.WaitTimeout))
+	}
+
+	now := time.Now()
+	opts.Window, err = parseWindow(opts.Since, now)
+	if err != nil {
+		return usageError(err)
+	}
+
+	opts.MaxWindow = defaultMaxWindow(opts.Window, now)
+	if opts.MaxLookback != "" {
+		opts.MaxWindow, err = parseWindow(opts.MaxLookback, now)
+		if err != nil {
+			return usageError(fmt.Errorf("invalid -maxLookback: %w", err))
+		}
+	}
+
+	if (opts.MaxWindow.Commits > 0) != (opts.Window.Commits > 0) {
+		return usageError(fmt.Errorf("-since %q and -maxLookback %q must both be times or both be numbers of commits", opts.Since, opts.MaxLookback))
+	}
+
+	return nil
+}
+
+// Config is the content of a config file, a set of named profiles per repository or environment
+type Config struct {
+	DefaultProfile string              `yaml:"defaultProfile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile holds the options of a repository or environment, flags override them
+type Profile struct {
+	Owner           string   `yaml:"owner"`
+	Repo            string   `yaml:"repo"`
+	Source          string   `yaml:"source"`
+	Path            string   `yaml:"path"`
+	Paths           []string `yaml:"paths"`
+	BranchPrefix    string   `yaml:"branchPrefix"`
+	IncludeBranches []string `yaml:"includeBranches"`
+	ExcludeBranches []string `yaml:"excludeBranches"`
+	IgnoreBranches  []string `yaml:"ignoreBranches"`
+	Protection      string   `yaml:"protection"`
+	Since           string   `yaml:"since"`
+	MaxLookback     string   `yaml:"maxLookback"`
+	Walk            string   `yaml:"walk"`
+	RevertScope     string   `yaml:"revertScope"`
+	Strategy        string   `yaml:"strategy"`
+	MessagePatterns []string `yaml:"messagePatterns"`
+	SourceRepos     []string `yaml:"sourceRepos"`
+	Output          string   `yaml:"output"`
+	Push            *bool    `yaml:"push"`
+	Deliver         string   `yaml:"deliver"`
+	Reviewers       []string `yaml:"reviewers"`
+	Labels          []string `yaml:"labels"`
+	AutoMerge       *bool    `yaml:"autoMerge"`
+	OnConflict      string   `yaml:"onConflict"`
+	Atomic          *bool    `yaml:"atomic"`
+	Freeze          *bool    `yaml:"freeze"`
+	Workflows       []string `yaml:"workflows"`
+	FreezeState     string   `yaml:"freezeState"`
+	Wait            *bool    `yaml:"wait"`
+	WaitTimeout     string   `yaml:"waitTimeout"`
+}
+
+// apply sets the options configured in the profile, unless their flag was given
+func (p *Profile) apply(opts *Options, set map[string]bool) {
+
+	setString := func(flagName string, value string, target *string) {
+		if value != "" && !set[flagName] {
+			*target = value
+		}
+	}
+	setList := func(flagName string, value []string, target *[]string) {
+		if value != nil && !set[flagName] {
+			*target = value
+		}
+	}
+
+	setString("owner", p.Owner, &opts.Owner)
+	setString("repo", p.Repo, &opts.Repo)
+	setString("source", p.Source, &opts.Source)
+	if p.Path != "" {
+		setList("path", []string{p.Path}, &opts.Paths)
+	}
+	setList("path", p.Paths, &opts.Paths)
+	setString("branchPrefix", p.BranchPrefix, &opts.BranchPrefix)
+	setList("includeBranches", p.IncludeBranches, &opts.IncludeBranches)
+	setList("excludeBranches", p.ExcludeBranches, &opts.ExcludeBranches)
+	setList("ignoreBranches", p.IgnoreBranches, &opts.IgnoreBranches)
+	setString("protection", p.Protection, &opts.Protection)
+	setList("messagePattern", p.MessagePatterns, &opts.MessagePatterns)
+	setList("sourceRepos", p.SourceRepos, &opts.SourceRepos)
+	setString("since", p.Since, &opts.Since)
+	setString("maxLookback", p.MaxLookback, &opts.MaxLookback)
+	setString("walk", p.Walk, &opts.Walk)
+	setString("revertScope", p.RevertScope, &opts.RevertScope)
+	setString("strategy", p.Strategy, &opts.Strategy)
+	setString("output", p.Output, &opts.Output)
+
+	setString("deliver", p.Deliver, &opts.Deliver)
+	setList("reviewers", p.Reviewers, &opts.Reviewers)
+	setList("labels", p.Labels, &opts.Labels)
+	setString("onConflict", p.OnConflict, &opts.OnConflict)
+	setList("workflows", p.Workflows, &opts.Workflows)
+	setString("freezeState", p.FreezeState, &opts.FreezeState)
+	setString("waitTimeout", p.WaitTimeout, &opts.WaitTimeout)
+
+	if p.Push != nil && !set["push"] {
+		opts.Push = *p.Push
+	}
+	if p.AutoMerge != nil && !set["autoMerge"] {
+		opts.AutoMerge = *p.AutoMerge
+	}
+	if p.Atomic != nil && !set["atomic"] {
+		opts.Atomic = *p.Atomic
+	}
+	if p.Freeze != nil && !set["freeze"] {
+		opts.Freeze = *p.Freeze
+	}
+	if p.Wait != nil && !set["wait"] {
+		opts.Wait = *p.Wait
+	}
+}
+
+// findConfigFile returns the config file to use, or an empty string if there is none
+func findConfigFile(configFile string) (string, error) {
+
+	if configFile != "" {
+		return configFile, nil
+	}
+
+	// os.UserConfigDir isn't ~/.config on macOS, the fallback is the XDG one on every platform
+	candidates := []string{configFileName}
+	if configDir := os.Getenv("XDG_CONFIG_HOME"); configDir != "" {
+		candidates = append(candidates, filepath.Join(configDir, "gitops-rollback", "config.yaml"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "gitops-rollback", "config.yaml"))
+	}
+
+	for _, candidate := range candidates {
+		_, err := os.Stat(candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// loadProfile reads the config file and returns the selected profile, or nil if there is no config file
+func loadProfile(configFile, profileName string) (*Profile, error) {
+
+	configFile, err := findConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile == "" {
+		if profileName != "" {
+			return nil, fmt.Errorf("profile %q requested but no config file found", profileName)
+		}
+		return nil, nil
+	}
+
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &Config{}
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+	}
+
+	if profileName == "" {
+		profileName = config.DefaultProfile
+	}
+
+	if profileName == "" {
+		switch len(config.Profiles) {
+		case 0:
+			return nil, nil
+		case 1:
+			for name := range config.Profiles {
+				profileName = name
+			}
+		default:
+			names := make([]string, 0, len(config.Profiles))
+			for name := range config.Profiles {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("config file %s has several profiles (%s), select one with -profile", configFile, strings.Join(names, ", "))
+		}
+	}
+
+	profile, ok := config.Profiles[profileName]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile %q not found in config file %s", profileName, configFile)
+	}
+
+	log.Printf("Using profile %s from config file %s", profileName, configFile)
+
+	return profile, nil
+}
+
+// stringListFlag is a flag that can be repeated to build a list
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// replaceListFlag is a flag that can be repeated to build a list, the first value replaces the default
+type replaceListFlag struct {
+	values *[]string
+	set    bool
+}
+
+func (f *replaceListFlag) String() string {
+	if f == nil || f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f *replaceListFlag) Set(value string) error {
+	if !f.set {
+		*f.values = nil
+		f.set = true
+	}
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+// commaListFlag is a comma-separated list flag, setting it replaces the default
+type commaListFlag []string
+
+func (f *commaListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *commaListFlag) Set(value string) error {
+	*f = make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*f = append(*f, item)
+		}
+	}
+	return nil
+}
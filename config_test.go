@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testConfig = `
+defaultProfile: hsw-prod
+profiles:
+  hsw-prod:
+    owner: trivago
+    repo: hotel-search-web
+    path: manifests/api/prod
+    ignoreBranches: [gitops/sink, gitops/infra]
+    since: 3
+    messagePatterns: [trailer]
+    push: true
+  hsw-stage:
+    repo: hotel-search-web
+    path: manifests/api/stage
+    branchPrefix: deploy/
+  hsw-deliver:
+    repo: hotel-search-web
+    deliver: push
+`
+
+func TestLoadOptionsWithProfile(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(testConfig), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	load := func(args ...string) (*Options, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := defaultOptions()
+		registerAnalysisFlags(fs, opts)
+		opts.registerPushFlags(fs)
+		err := loadOptions(fs, append([]string{"-config", configFile}, args...), opts)
+		return opts, err
+	}
+
+	opts, err := load("-since", "6")
+	if err != nil {
+		t.Fatalf("Failed to load options: %v", err)
+	}
+
+	if opts.Repo != "hotel-search-web" || opts.Since != "6" || opts.Window.Since == nil || !opts.Push {
+		t.Errorf("Unexpected options from the default profile: %+v", opts)
+	}
+	if !slices.Equal(opts.IgnoreBranches, []string{"gitops/sink", "gitops/infra"}) || !slices.Equal(opts.MessagePatterns, []string{"trailer"}) {
+		t.Errorf("Unexpected list options from the default profile: %+v", opts)
+	}
+
+	opts, err = load("-path", "manifests/api/prod", "-path", "manifests/api-worker/prod")
+	if err != nil {
+		t.Fatalf("Failed to load options: %v", err)
+	}
+
+	if !slices.Equal(opts.Paths, []string{"manifests/api/prod", "manifests/api-worker/prod"}) {
+		t.Errorf("Expected the repeated -path flags to replace the default paths, got %v", opts.Paths)
+	}
+
+	opts, err = load("-profile", "hsw-stage", "-path", "manifests/api/canary")
+	if err != nil {
+		t.Fatalf("Failed to load options: %v", err)
+	}
+
+	if !slices.Equal(opts.Paths, []string{"manifests/api/canary"}) || opts.BranchPrefix != "deploy/" || opts.Owner != "trivago" || opts.Push {
+		t.Errorf("Unexpected options from the stage profile: %+v", opts)
+	}
+
+	if _, err := load("-profile", "missing"); err == nil {
+		t.Errorf("Expected an error for a missing profile")
+	}
+
+	// -push overrides the delivery of the profile, unless -deliver is given too
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: DeliverPush},
+		{args: []string{"-push=false"}, want: DeliverLocal},
+		{args: []string{"-push=false", "-deliver", "pr"}, want: DeliverPR},
+	} {
+		opts, err := load(append([]string{"-profile", "hsw-deliver"}, tt.args...)...)
+		if err != nil {
+			t.Fatalf("Failed to load options: %v", err)
+		}
+		if opts.Deliver != tt.want {
+			t.Errorf("Expected %v to deliver with %s, got %s", tt.args, tt.want, opts.Deliver)
+		}
+	}
+}
+
+func TestLoadOptionsRejectsUnknownConfigKeys(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("profiles:\n  prod:\n    reop: typo\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := defaultOptions()
+	opts.registerRepoFlags(fs)
+	if err := loadOptions(fs, []string{"-config", configFile}, opts); err == nil {
+		t.Errorf("Expected an error for an unknown config key")
+	}
+}
+
+func TestLoadOptionsUsageErrors(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	load := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		opts := defaultOptions()
+		registerAnalysisFlags(fs, opts)
+		return loadOptions(fs, append([]string{"-config", configFile}, args...), opts)
+	}
+
+	for _, args := range [][]string{{"-unknown"}, {"-strategy", "squash"}, {"-protection", "locked"}, {"-since", "yesterday"}, {"extra"}} {
+		var exit *exitError
+		if err := load(args...); !errors.As(err, &exit) || exit.code != ExitUsage {
+			t.Errorf("Expected %v to be a wrong usage, got %v", args, err)
+		}
+	}
+
+	if err := load("-h"); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected -h to return flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "full sha", opts: Options{Target: "ae0b50669b483e0c91227ea639c025382ba3c24c"}},
+		{name: "short sha", opts: Options{Target: "ae0b506"}},
+		{name: "tag", opts: Options{ToTag: "v1.42.0"}},
+		{name: "pull request", opts: Options{ToPR: 1234}},
+		{name: "release", opts: Options{ToRelease: "latest"}},
+		{name: "time", opts: Options{ToTime: "2026-10-15T14:05Z"}},
+		{name: "bad commit", opts: Options{BadCommit: "ae0b506"}},
+		{name: "bad commit and target", opts: Options{BadCommit: "ae0b506", Target: "f50d95b"}, wantErr: true},
+		{name: "no target", opts: Options{}, wantErr: true},
+		{name: "two targets", opts: Options{ToTag: "v1.42.0", ToPR: 1234}, wantErr: true},
+		{name: "too short sha", opts: Options{Target: "ae0b5"}, wantErr: true},
+		{name: "not a sha", opts: Options{Target: "master"}, wantErr: true},
+		{name: "invalid time", opts: Options{ToTime: "yesterday"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := tt.opts.validateTarget()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateTarget() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFindConfigFileFallback(t *testing.T) {
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	fallback := filepath.Join(home, ".config", "gitops-rollback", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(fallback), 0o755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(fallback, []byte(testConfig), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if got, err := findConfigFile(""); err != nil || got != fallback {
+		t.Errorf("Expected the fallback config file %s, got %q, %v", fallback, got, err)
+	}
+
+	// $XDG_CONFIG_HOME replaces ~/.config when it is set
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if got, err := findConfigFile(""); err != nil || got != "" {
+		t.Errorf("Expected no config file under $XDG_CONFIG_HOME, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// Outcomes of the deploy of a pushed rollback
+const (
+	// DeploySucceeded is a branch whose workflow runs all succeeded
+	DeploySucceeded = "succeeded"
+	// DeployFailed is a branch with a workflow run that didn't succeed
+	DeployFailed = "failed"
+	// DeployTimedOut is a branch with a workflow run still running at the wait timeout
+	DeployTimedOut = "timed-out"
+	// DeployNone is a branch whose push triggered no workflow run before the wait timeout
+	DeployNone = "none"
+)
+
+// deployPollInterval is the time between two listings of the workflow runs of a pushed rollback
+const deployPollInterval = 15 * time.Second
+
+// DeployRun is a workflow run triggered by the push of a rollback
+type DeployRun struct {
+	Workflow   string `json:"workflow" yaml:"workflow"`
+	Status     string `json:"status" yaml:"status"`
+	Conclusion string `json:"conclusion,omitempty" yaml:"conclusion,omitempty"`
+	URL        string `json:"url" yaml:"url"`
+}
+
+// waitDeploys waits for the workflow runs of every pushed branch and records their outcome in the results
+func (d *Delivery) waitDeploys(results []BranchResult, repoDir string) {
+
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].Status != StatusPushed {
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *BranchResult) {
+			defer wg.Done()
+
+			sha, err := localBranchHead(repoDir, result.Branch)
+			if err != nil {
+				log.Printf("Failed to find the pushed head of branch %s: %v", result.Branch, err)
+				result.Deploy = DeployFailed
+				return
+			}
+
+			log.Printf("Waiting up to %v for the workflow runs of %s on branch %s", d.WaitTimeout, sha, result.Branch)
+			result.Deploy, result.Runs = d.waitDeploy(result.Branch, sha)
+			log.Printf("Deploy of branch %s %s", result.Branch, result.Deploy)
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// waitDeploy polls the workflow runs of a pushed commit until they are all completed or the wait times out
+func (d *Delivery) waitDeploy(branch, sha string) (string, []DeployRun) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.WaitTimeout)
+	defer cancel()
+
+	var runs []DeployRun
+	for {
+		workflowRuns, err := d.client.ListCommitWorkflowRuns(ctx, branch, sha)
+		if err != nil {
+			log.Printf("Failed to list the workflow runs of branch %s, retrying: %v", branch, err)
+		} else {
+			runs = deployRuns(workflowRuns, d.frozen)
+			if deploy, done := deployOutcome(runs); done {
+				return deploy, runs
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if len(runs) == 0 {
+				return DeployNone, runs
+			}
+			return DeployTimedOut, runs
+		case <-time.After(deployPollInterval):
+		}
+	}
+}
+
+// deployRuns returns the workflow runs sorted by workflow, leaving out the runs of the frozen workflows
+func deployRuns(workflowRuns []*github.WorkflowRun, frozen map[int64]bool) []DeployRun {
+
+	runs := make([]DeployRun, 0, len(workflowRuns))
+	for _, run := range workflowRuns {
+		if frozen[run.GetWorkflowID()] {
+			continue
+		}
+		runs = append(runs, DeployRun{
+			Workflow:   run.GetName(),
+			Status:     run.GetStatus(),
+			Conclusion: run.GetConclusion(),
+			URL:        run.GetHTMLURL(),
+		})
+	}
+	slices.SortFunc(runs, func(x, y DeployRun) int { return strings.Compare(x.Workflow, y.Workflow) })
+
+	return runs
+}
+
+// deployOutcome returns the outcome of the deploy once every run is completed, a run concluding
+// with anything but success, neutral or skipped fails the deploy
+func deployOutcome(runs []DeployRun) (string, bool) {
+
+	if len(runs) == 0 {
+		return "", false
+	}
+
+	deploy := DeploySucceeded
+	for _, run := range runs {
+		if run.Status != "completed" {
+			return "", false
+		}
+		if !slices.Contains([]string{"success", "neutral", "skipped"}, run.Conclusion) {
+			deploy = DeployFailed
+		}
+	}
+
+	return deploy, true
+}
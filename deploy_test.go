@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeployOutcome(t *testing.T) {
+
+	succeeded := DeployRun{Workflow: "Deploy", Status: "completed", Conclusion: "success"}
+	skipped := DeployRun{Workflow: "Lint", Status: "completed", Conclusion: "skipped"}
+	failed := DeployRun{Workflow: "Deploy", Status: "completed", Conclusion: "failure"}
+	running := DeployRun{Workflow: "Smoke tests", Status: "in_progress"}
+
+	tests := []struct {
+		name string
+		runs []DeployRun
+		want string
+		done bool
+	}{
+		{name: "no run yet", runs: nil, want: "", done: false},
+		{name: "still running", runs: []DeployRun{succeeded, running}, want: "", done: false},
+		{name: "succeeded", runs: []DeployRun{succeeded, skipped}, want: DeploySucceeded, done: true},
+		{name: "failed", runs: []DeployRun{failed, skipped}, want: DeployFailed, done: true},
+	}
+
+	for _, tt := range tests {
+		if got, done := deployOutcome(tt.runs); got != tt.want || done != tt.done {
+			t.Errorf("%s: deployOutcome() = %q, %v, want %q, %v", tt.name, got, done, tt.want, tt.done)
+		}
+	}
+}
+
+func TestWaitDeployIgnoresFrozenWorkflows(t *testing.T) {
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/trivago/gitops/actions/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("head_sha") != sha || r.URL.Query().Get("branch") != "gitops/api" {
+			t.Errorf("Unexpected runs listing: %s", r.URL.RawQuery)
+		}
+		// The run of the frozen workflow 42 never completes
+		fmt.Fprint(w, `{"total_count": 2, "workflow_runs": [
+			{"id": 7, "workflow_id": 42, "name": "Deploy", "status": "queued"},
+			{"id": 8, "workflow_id": 50, "name": "Smoke tests", "status": "completed", "conclusion": "success", "html_url": "https://github.com/trivago/gitops/actions/runs/8"}
+		]}`)
+	})
+
+	delivery := &Delivery{client: newTestGithubClient(t, mux), WaitTimeout: time.Minute, frozen: map[int64]bool{42: true}}
+
+	deploy, runs := delivery.waitDeploy("gitops/api", sha)
+	if deploy != DeploySucceeded {
+		t.Errorf("Expected the deploy to succeed without waiting for the frozen workflow, got %s", deploy)
+	}
+	if len(runs) != 1 || runs[0].Workflow != "Smoke tests" {
+		t.Errorf("Expected only the run of the workflow that isn't frozen, got %+v", runs)
+	}
+}
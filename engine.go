@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
+	"path"
 	"regexp"
 	"slices"
 	"strings"
@@ -11,13 +13,13 @@ import (
 	"github.com/google/go-github/v71/github"
 )
 
-// processHeadCommits processes all commits and returns a map of commits with their parent and date
+// processHeadCommits processes all commits and returns a map of commits with their parents and date
 /*
 Output:
 
 {
 	"sha1": {
-		"parent": "sha2",
+		"parents": ["sha2", "sha5"],
 		"date": "2021-01-01",
 		"gitops_commits": {
 			"gitops/branch1": {
@@ -34,9 +36,15 @@ func processHeadCommits(commits []*github.RepositoryCommit) map[string]*HeadComm
 	headCommits := make(map[string]*HeadCommit)
 
 	for _, commit := range commits {
+		// A root commit has no parents
+		parents := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parents[i] = parent.GetSHA()
+		}
+
 		headCommits[commit.GetSHA()] = &HeadCommit{
 			SHA:           commit.GetSHA(),
-			Parent:        commit.Parents[0].GetSHA(),
+			Parents:       parents,
 			Date:          commit.GetCommit().GetAuthor().GetDate().Local(),
 			GitOpsCommits: make(map[string]GitOpsCommit),
 		}
@@ -45,37 +53,67 @@ func processHeadCommits(commits []*github.RepositoryCommit) map[string]*HeadComm
 	return headCommits
 }
 
-func listGitOpsBranches(owner, repo string, ignore []string) ([]string, error) {
+// listGitOpsBranches lists the branches selected as gitops branches, with their protection status and required checks.
+// Every other branch is returned with the reason it was excluded.
+func listGitOpsBranches(source BranchSource, selector *BranchSelector) (branches []GitOpsBranch, excluded []ExcludedBranch, err error) {
 
-	client, err := NewGithubClient(owner, repo)
+	// List every branch so that the exclusions can be reported
+	allBranches, err := source.ListBranches(context.Background(), func(string) bool { return true }, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	filter := func(branch string) bool {
-		return strings.HasPrefix(branch, "gitops/") && !slices.Contains(ignore, branch)
-	}
+	branches = make([]GitOpsBranch, 0)
+	excluded = make([]ExcludedBranch, 0)
+
+	for _, branch := range allBranches {
+		candidate, reason := selector.exclusionReason(branch.GetName(), branch.Protected)
+		if reason != "" {
+			excluded = append(excluded, ExcludedBranch{
+				Branch:    branch.GetName(),
+				Reason:    reason,
+				candidate: candidate,
+			})
+			continue
+		}
 
-	gitopsBranches, err := client.ListBranches(context.Background(), filter, true)
-	if err != nil {
-		return nil, err
+		gitopsBranch := GitOpsBranch{
+			Name:      branch.GetName(),
+			Head:      branch.GetCommit().GetSHA(),
+			Protected: branch.Protected,
+		}
+
+		if branch.GetProtected() {
+			gitopsBranch.RequiredChecks, err = source.RequiredChecks(context.Background(), gitopsBranch.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get required checks of branch %s: %w", gitopsBranch.Name, err)
+			}
+		}
+
+		branches = append(branches, gitopsBranch)
 	}
 
-	branches := make([]string, len(gitopsBranches))
-	for i, branch := range gitopsBranches {
-		branches[i] = branch.GetName()
+	return branches, excluded, nil
+}
+
+// branchNames returns the names of the given branches
+func branchNames(branches []GitOpsBranch) []string {
+
+	names := make([]string, len(branches))
+	for i, branch := range branches {
+		names[i] = branch.Name
 	}
 
-	return branches, nil
+	return names
 }
 
-// generateCommitGraph generates a commit graph for a given repository and path
+// generateCommitGraph generates a commit graph for a given repository and paths
 /*
 Output:
 
 {
 	"sha1": {
-		"parent": "sha2",
+		"parents": ["sha2"],
 		"date": "2021-01-01",
 		"gitops_commits": {
 			"gitops/branch1": {
@@ -90,122 +128,386 @@ Output:
 	}
 }
 */
-func generateCommitGraph(owner, repo string, gitopsBranches []string, headCommits map[string]*HeadCommit, path string) (commitsGraph map[string]*HeadCommit, commitsHistory map[string][]string, err error) {
-
-	client, err := NewGithubClient(owner, repo)
-	if err != nil {
-		return nil, nil, err
-	}
+func generateCommitGraph(source Source, window Window, gitopsBranches []string, headCommits map[string]*HeadCommit, paths []string, matcher *ReferenceMatcher) (commitsGraph map[string]*HeadCommit, commitsHistory map[string][]GitOpsCommit, err error) {
 
 	commitsGraph = make(map[string]*HeadCommit, len(headCommits))
 	for sha, commit := range headCommits {
 		commitsGraph[sha] = commit
 	}
 
-	since := time.Now().AddDate(0, -1, 0)
-
-	commitsHistory = make(map[string][]string)
+	commitsHistory = make(map[string][]GitOpsCommit)
 
 	for _, branch := range gitopsBranches {
-		branchCommits, err := client.ListCommitsSinceOnPath(context.Background(), since, branch, path)
+		branchPaths, err := expandPaths(source, branch, paths)
 		if err != nil {
+			log.Printf("Failed to expand paths on branch %s, skipping: %v", branch, err)
 			continue
 		}
 
-		for _, commit := range branchCommits {
+		branchCommits, err := listCommitsOnPaths(source, window, branch, branchPaths)
+		if err != nil {
+			log.Printf("Failed to list the commits on the paths of branch %s, skipping: %v", branch, err)
+			continue
+		}
 
-			// Add the commit to the history
-			commitsHistory[branch] = append(commitsHistory[branch], commit.GetSHA())
+		// Add the commits to the history
+		commitsHistory[branch] = branchCommits
 
-			message := commit.GetCommit().GetMessage()
-			repoSHAPattern := regexp.MustCompile(`[\w-]+/[\w-]+@([0-9a-f]{40})`)
-			matches := repoSHAPattern.FindStringSubmatch(message)
-			var extractedSHA string
-			if len(matches) > 1 {
-				extractedSHA = matches[1]
+		linkGitOpsCommits(commitsGraph, branch, branchCommits, matcher)
+
+	}
+
+	return commitsGraph, commitsHistory, nil
+}
+
+// linkGitOpsCommits links the history of a gitops branch to the master commits its commits deploy
+func linkGitOpsCommits(commitsGraph map[string]*HeadCommit, branch string, branchCommits []GitOpsCommit, matcher *ReferenceMatcher) {
+
+	for _, gitopsCommit := range branchCommits {
+
+		// Only commits referencing a commit in the head commits map are linked
+		extractedSHA := matcher.Resolve(gitopsCommit.Message, commitsGraph)
+		if extractedSHA == "" {
+			continue
+		}
+
+		commitsGraph[extractedSHA].GitOpsCommits[branch] = gitopsCommit
+
+	}
+}
+
+// expandPaths expands the glob paths against the tree of a branch, other paths are kept as they are
+func expandPaths(source TreeSource, branch string, paths []string) ([]string, error) {
+
+	var tree []string
+	expanded := make([]string, 0, len(paths))
+
+	for _, pattern := range paths {
+		pattern = strings.Trim(pattern, "/")
+		if !strings.ContainsAny(pattern, "*?[") {
+			expanded = append(expanded, pattern)
+			continue
+		}
+
+		// The tree is only listed once per branch, and only if a glob is used
+		if tree == nil {
+			var err error
+			tree, err = source.ListPaths(context.Background(), branch)
+			if err != nil {
+				return nil, err
 			}
+		}
 
-			if extractedSHA == "" {
-				continue
+		matched := false
+		for _, p := range tree {
+			if ok, _ := path.Match(pattern, p); ok {
+				expanded = append(expanded, p)
+				matched = true
 			}
+		}
+
+		if !matched {
+			log.Printf("Path %s matches nothing on branch %s", pattern, branch)
+		}
+	}
 
-			// fmt.Printf("Branch: %s\n", branch)
-			// fmt.Printf("Extracted SHA: %s\n", extractedSHA)
+	slices.Sort(expanded)
+	return slices.Compact(expanded), nil
+}
 
-			// Check if the commit is in the head commits map
-			if _, ok := commitsGraph[extractedSHA]; !ok {
-				continue
+// listCommitsOnPaths lists the commits of a branch touching any of the paths, newest to oldest.
+// A commit touching several paths is listed once, with all the paths it touched.
+func listCommitsOnPaths(source CommitSource, window Window, branch string, paths []string) ([]GitOpsCommit, error) {
+
+	commits := make([]GitOpsCommit, 0)
+	index := make(map[string]int)
+	var oldest time.Time
+
+	for _, p := range paths {
+		pathCommits, err := source.ListCommitsSinceOnPath(context.Background(), window, branch, p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, commit := range pathCommits {
+			if committed := commit.GetCommit().GetCommitter().GetDate().Time; oldest.IsZero() || committed.Before(oldest) {
+				oldest = committed
 			}
 
-			commitsGraph[extractedSHA].GitOpsCommits[branch] = GitOpsCommit{
-				SHA:  commit.GetSHA(),
-				Date: commit.GetCommit().GetAuthor().GetDate().Local(),
+			if i, ok := index[commit.GetSHA()]; ok {
+				commits[i].Paths = append(commits[i].Paths, p)
+				continue
 			}
 
+			index[commit.GetSHA()] = len(commits)
+			commits = append(commits, GitOpsCommit{
+				SHA:     commit.GetSHA(),
+				Date:    commit.GetCommit().GetAuthor().GetDate().Local(),
+				Author:  commit.GetCommit().GetAuthor().GetName(),
+				Message: commit.GetCommit().GetMessage(),
+				Paths:   []string{p},
+			})
+		}
+	}
+
+	// Each path lists the commits in order, merge them back into a single history in the order of the branch.
+	// Author dates can't order them, a cherry-picked or rebased commit keeps the date it was first authored.
+	if len(paths) > 1 && len(commits) > 1 {
+		history, err := source.ListCommitsSince(context.Background(), sinceWindow(oldest), branch)
+		if err != nil {
+			return nil, err
+		}
+
+		position := make(map[string]int, len(history))
+		for i, commit := range history {
+			position[commit.GetSHA()] = i
 		}
 
+		for _, commit := range commits {
+			if _, ok := position[commit.SHA]; !ok {
+				return nil, fmt.Errorf("commit %s on the paths of branch %s not found in its history since %s", commit.SHA, branch, oldest.Format(time.RFC3339))
+			}
+		}
+
+		slices.SortStableFunc(commits, func(x, y GitOpsCommit) int { return position[x.SHA] - position[y.SHA] })
 	}
 
-	return commitsGraph, commitsHistory, nil
+	return commits, nil
 }
 
-// findRollbackCommits finds rollback commits for a given head commit on gitops branches
-// It tries to find what is the last commit on gitops branches that is before the candidate commit
-func findRollbackCommits(commitsGraph map[string]*HeadCommit, gitopsBranches []string, candidateCommit string) (map[string]RollbackCommit, error) {
+// commitPaths returns the analyzed paths touched by any of the commits
+func commitPaths(commits []GitOpsCommit) []string {
 
-	rollbackCommits := make(map[string]RollbackCommit, len(gitopsBranches))
+	paths := make([]string, 0)
+	for _, commit := range commits {
+		paths = append(paths, commit.Paths...)
+	}
 
-	branchesToCheck := make(map[string]bool, len(gitopsBranches))
-	for _, b := range gitopsBranches {
-		branchesToCheck[b] = true
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+// pathContains reports whether a file is the path or under it
+func pathContains(p, file string) bool {
+	return p == "" || file == p || strings.HasPrefix(file, p+"/")
+}
+
+// outsidePaths returns the files that are under none of the paths
+func outsidePaths(files, paths []string) []string {
+
+	var outside []string
+	for _, file := range files {
+		if !slices.ContainsFunc(paths, func(p string) bool { return pathContains(p, file) }) {
+			outside = append(outside, file)
+		}
+	}
+
+	slices.Sort(outside)
+	return slices.Compact(outside)
+}
+
+// Ancestry walks of the master history
+const (
+	WalkFirstParent = "first-parent"
+	WalkFull        = "full"
+)
+
+// ancestors lists the ancestors of a commit in the commits graph, the commit first and then by distance.
+// The first-parent walk only follows the first parent of merge commits, the full walk follows all of them.
+func ancestors(commitsGraph map[string]*HeadCommit, sha, walk string) []string {
+
+	if _, ok := commitsGraph[sha]; !ok {
+		return nil
 	}
 
+	visited := map[string]bool{sha: true}
+	queue := []string{sha}
+
+	for i := 0; i < len(queue); i++ {
+		parents := commitsGraph[queue[i]].Parents
+		if walk == WalkFirstParent && len(parents) > 1 {
+			parents = parents[:1]
+		}
+
+		for _, parent := range parents {
+			// Stop at the edge of the fetched history
+			if _, ok := commitsGraph[parent]; !ok || visited[parent] {
+				continue
+			}
+			visited[parent] = true
+			queue = append(queue, parent)
+		}
+	}
+
+	return queue
+}
+
+// findRollbackCommits finds rollback commits for a given head commit on gitops branches.
+// For every branch, the anchor is the newest deploy of the candidate commit or one of its ancestors,
+// the nearest ancestor wins if two deploys have the same date. The walk stops at the edge of the commits graph,
+// the branches without anchor are reported as never deployed or deployed after the candidate commit.
+func findRollbackCommits(commitsGraph map[string]*HeadCommit, commitsHistory map[string][]GitOpsCommit, gitopsBranches []string, candidateCommit, walk string) (*AnchorResult, error) {
+
 	if _, ok := commitsGraph[candidateCommit]; !ok {
 		return nil, fmt.Errorf("candidate commit not found in commits graph")
 	}
 
-	commitToCheck := candidateCommit
-	for {
+	result := &AnchorResult{
+		Anchors:              make(map[string]RollbackCommit, len(gitopsBranches)),
+		NeverDeployed:        make([]string, 0),
+		DeployedAfterTarget:  make(map[string]RollbackCommit),
+		DeployedNonAncestors: make(map[string]RollbackCommit),
+	}
 
-		if len(branchesToCheck) == 0 {
-			break
-		}
+	// The newest deploy is the one with the lowest position in the branch history, author dates
+	// can't order the deploys, a cherry-picked or rebased commit keeps the date it was first authored
+	positions := make(map[string]map[string]int, len(gitopsBranches))
+	for _, b := range gitopsBranches {
+		positions[b] = historyPositions(commitsHistory[b])
+	}
+	anchorPositions := make(map[string]int, len(gitopsBranches))
 
-		gitopsCommits := commitsGraph[commitToCheck].GitOpsCommits
-		for b, c := range gitopsCommits {
-			if !branchesToCheck[b] {
+	for _, sha := range ancestors(commitsGraph, candidateCommit, walk) {
+		for _, b := range gitopsBranches {
+			c, ok := commitsGraph[sha].GitOpsCommits[b]
+			if !ok {
 				continue
 			}
 
-			// Remove the branch from the branches to check
-			delete(branchesToCheck, b)
+			position, ok := positions[b][c.SHA]
+			if !ok {
+				continue
+			}
+			if anchor, found := anchorPositions[b]; found && position >= anchor {
+				continue
+			}
 
-			rollbackCommits[b] = RollbackCommit{
+			anchorPositions[b] = position
+			result.Anchors[b] = RollbackCommit{
 				GitOpsCommit: c.SHA,
-				HeadCommit:   commitToCheck,
+				HeadCommit:   sha,
 			}
 		}
+	}
+
+	for _, b := range gitopsBranches {
+		if _, ok := result.Anchors[b]; ok {
+			continue
+		}
 
-		commitToCheck = commitsGraph[commitToCheck].Parent
+		oldest, ok := oldestDeploy(commitsGraph, positions[b], b)
+		if !ok {
+			result.NeverDeployed = append(result.NeverDeployed, b)
+			continue
+		}
+
+		// A deploy older than the target that isn't an anchor deployed a commit of another line of history
+		deployedAt := commitsGraph[oldest.HeadCommit].GitOpsCommits[b].Date
+		if deployedAt.After(commitsGraph[candidateCommit].Date) {
+			result.DeployedAfterTarget[b] = oldest
+		} else {
+			result.DeployedNonAncestors[b] = oldest
+		}
 	}
+	slices.Sort(result.NeverDeployed)
 
-	return rollbackCommits, nil
+	return result, nil
+}
+
+// oldestDeploy returns the oldest deploy of a master commit on a gitops branch in the commits graph,
+// the one with the highest position in the branch history
+func oldestDeploy(commitsGraph map[string]*HeadCommit, positions map[string]int, branch string) (RollbackCommit, bool) {
+
+	var oldest RollbackCommit
+	oldestPosition := -1
+
+	for sha, commit := range commitsGraph {
+		c, ok := commit.GitOpsCommits[branch]
+		if !ok {
+			continue
+		}
+
+		position, ok := positions[c.SHA]
+		if !ok || position <= oldestPosition {
+			continue
+		}
+
+		oldest = RollbackCommit{GitOpsCommit: c.SHA, HeadCommit: sha}
+		oldestPosition = position
+	}
+
+	return oldest, oldestPosition >= 0
+}
+
+// historyPositions returns the position of every commit in a branch history, 0 is the newest
+func historyPositions(history []GitOpsCommit) map[string]int {
+
+	positions := make(map[string]int, len(history))
+	for i, commit := range history {
+		positions[commit.SHA] = i
+	}
+
+	return positions
+}
+
+// Unanchored returns the branches without anchor, sorted
+func (r *AnchorResult) Unanchored() []string {
+
+	branches := slices.Clone(r.NeverDeployed)
+	for b := range r.DeployedAfterTarget {
+		branches = append(branches, b)
+	}
+	for b := range r.DeployedNonAncestors {
+		branches = append(branches, b)
+	}
+	slices.Sort(branches)
+
+	return branches
+}
+
+// findDeployedBefore finds the master commit with the latest deploy at or before a time, on any gitops branch
+func findDeployedBefore(commitsGraph map[string]*HeadCommit, before time.Time) (string, bool) {
+
+	var latest *HeadCommit
+	var latestDate time.Time
+
+	for _, commit := range commitsGraph {
+		for _, c := range commit.GitOpsCommits {
+			if c.Date.After(before) || c.Date.Before(latestDate) {
+				continue
+			}
+			// On the same deploy date, the newest master commit wins
+			if latest != nil && c.Date.Equal(latestDate) && !commit.Date.After(latest.Date) {
+				continue
+			}
+			latest = commit
+			latestDate = c.Date
+		}
+	}
+
+	if latest == nil {
+		return "", false
+	}
+
+	return latest.SHA, true
 }
 
 // findCommitsAfterRollback finds the commits after the rollback commit on the gitops branches
-func findCommitsAfterRollback(rollbackCommits map[string]RollbackCommit, commitsHistory map[string][]string) (map[string][]string, error) {
+func findCommitsAfterRollback(rollbackCommits map[string]RollbackCommit, commitsHistory map[string][]GitOpsCommit) (map[string][]GitOpsCommit, error) {
 
-	commitsAfterRollback := make(map[string][]string)
+	commitsAfterRollback := make(map[string][]GitOpsCommit)
 
 	for branch, r := range rollbackCommits {
 
 		branchCommits := commitsHistory[branch]
 
-		index := slices.Index(branchCommits, r.GitOpsCommit)
+		index := slices.IndexFunc(branchCommits, func(c GitOpsCommit) bool {
+			return c.SHA == r.GitOpsCommit
+		})
 
 		// If the commit is not found, skip the branch
 		if index == -1 {
-			fmt.Printf("Rollback commit not found in branch %s history, skipping\n", branch)
+			log.Printf("Rollback commit not found in branch %s history, skipping", branch)
 			continue
 		}
 
@@ -215,3 +517,80 @@ func findCommitsAfterRollback(rollbackCommits map[string]RollbackCommit, commits
 
 	return commitsAfterRollback, nil
 }
+
+// findDeployedCommits finds, for every gitops branch, the newest gitops commit on the path that deploys a known master commit
+func findDeployedCommits(branches []GitOpsBranch, commitsGraph map[string]*HeadCommit, commitsHistory map[string][]GitOpsCommit, matcher *ReferenceMatcher) []BranchStatus {
+
+	statuses := make([]BranchStatus, 0, len(branches))
+	for _, branch := range branches {
+		status := BranchStatus{
+			Branch: branch.Name,
+			Head:   branch.Head,
+		}
+
+		// History is newest to oldest, the commits undone by a later rollback are no longer deployed
+		undone := make([]string, 0)
+		for _, commit := range commitsHistory[branch.Name] {
+			if slices.ContainsFunc(undone, func(sha string) bool { return strings.HasPrefix(commit.SHA, sha) }) {
+				continue
+			}
+			if isRollbackCommit(commit.Message) {
+				undone = append(undone, undoneCommits(commit.Message)...)
+				continue
+			}
+			if sha := matcher.Resolve(commit.Message, commitsGraph); sha != "" {
+				status.GitOpsCommit = commit.SHA
+				status.DeployedCommit = sha
+				status.DeployedAt = commit.Date
+				break
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// revertedPattern matches the commit undone by a revert, of git revert or of a revert of paths
+var revertedPattern = regexp.MustCompile(`This reverts (?:the changes of )?commit ([0-9a-f]{7,40})`)
+
+// supersededPattern matches the commits listed by a restore as superseded
+var supersededPattern = regexp.MustCompile(`(?m)^- ([0-9a-f]{7,40}) `)
+
+// isRollbackCommit tells whether a commit reverts or restores other commits: it is written by the tool
+// or reverts a commit with git revert, keeping the subject of the deploy it reverts
+func isRollbackCommit(message string) bool {
+
+	return commitTrailer(message, TrailerRollbackID) != "" ||
+		commitTrailer(message, TrailerRollForward) != "" ||
+		strings.HasPrefix(message, "Revert \"")
+}
+
+// undoneCommits returns the SHAs, possibly abbreviated, of the commits reverted or superseded by a rollback commit
+func undoneCommits(message string) []string {
+
+	shas := make([]string, 0)
+	for _, match := range revertedPattern.FindAllStringSubmatch(message, -1) {
+		shas = append(shas, match[1])
+	}
+
+	if _, superseded, ok := strings.Cut(message, "\nSuperseded commits:\n"); ok {
+		for _, match := range supersededPattern.FindAllStringSubmatch(superseded, -1) {
+			shas = append(shas, match[1])
+		}
+	}
+
+	return shas
+}
+
+// commitSHAs returns the SHAs of the given commits, keeping their order
+func commitSHAs(commits []GitOpsCommit) []string {
+
+	shas := make([]string, len(commits))
+	for i, commit := range commits {
+		shas[i] = commit.SHA
+	}
+
+	return shas
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindRollbackCommitsBoundary(t *testing.T) {
+
+	now := time.Now()
+	deploy := func(sha string, age time.Duration) GitOpsCommit {
+		return GitOpsCommit{SHA: sha, Date: now.Add(-age)}
+	}
+
+	// m1 <- m2 <- m3, the parent of m1 is outside of the fetched history.
+	// r1 is a release branch commit, deployed before m2 without being one of its ancestors.
+	commitsGraph := map[string]*HeadCommit{
+		"m1": {SHA: "m1", Parents: []string{"m0"}, Date: now.Add(-4 * time.Hour), GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api": deploy("a1", 3*time.Hour),
+		}},
+		"r1": {SHA: "r1", Parents: []string{"m0"}, Date: now.Add(-4 * time.Hour), GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/hotfix": deploy("h1", 3*time.Hour),
+		}},
+		"m2": {SHA: "m2", Parents: []string{"m1"}, Date: now.Add(-2 * time.Hour), GitOpsCommits: map[string]GitOpsCommit{}},
+		"m3": {SHA: "m3", Parents: []string{"m2"}, Date: now.Add(-90 * time.Minute), GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api":    deploy("a3", time.Hour),
+			"gitops/worker": deploy("w3", time.Hour),
+			"gitops/hotfix": deploy("h3", time.Hour),
+		}},
+	}
+	branches := []string{"gitops/api", "gitops/worker", "gitops/idle", "gitops/hotfix"}
+	commitsHistory := map[string][]GitOpsCommit{
+		"gitops/api":    {{SHA: "a3"}, {SHA: "a1"}},
+		"gitops/worker": {{SHA: "w3"}},
+		"gitops/hotfix": {{SHA: "h3"}, {SHA: "h1"}},
+	}
+
+	result, err := findRollbackCommits(commitsGraph, commitsHistory, branches, "m2", WalkFirstParent)
+	if err != nil {
+		t.Fatalf("Failed to find rollback commits: %v", err)
+	}
+
+	if got := result.Anchors["gitops/api"]; got != (RollbackCommit{GitOpsCommit: "a1", HeadCommit: "m1"}) {
+		t.Errorf("Unexpected anchor for gitops/api: %+v", got)
+	}
+	if !slices.Equal(result.NeverDeployed, []string{"gitops/idle"}) {
+		t.Errorf("Unexpected never deployed branches: %v", result.NeverDeployed)
+	}
+	if got, ok := result.DeployedAfterTarget["gitops/worker"]; !ok || got.HeadCommit != "m3" {
+		t.Errorf("Expected gitops/worker to be deployed after the target only, got %+v", result.DeployedAfterTarget)
+	}
+	if got, ok := result.DeployedNonAncestors["gitops/hotfix"]; !ok || got.HeadCommit != "r1" {
+		t.Errorf("Expected gitops/hotfix to have deployed only non-ancestors of the target, got %+v", result.DeployedNonAncestors)
+	}
+	if _, ok := result.DeployedAfterTarget["gitops/hotfix"]; ok {
+		t.Errorf("Expected gitops/hotfix not to be reported as deployed after the target")
+	}
+	if got := result.Unanchored(); !slices.Equal(got, []string{"gitops/hotfix", "gitops/idle", "gitops/worker"}) {
+		t.Errorf("Unexpected unanchored branches: %v", got)
+	}
+	if got := unanchoredReason(result, "gitops/hotfix", Window{}); !strings.HasPrefix(got, "deployed only non-ancestors of the target") {
+		t.Errorf("Unexpected reason for gitops/hotfix: %q", got)
+	}
+
+	if _, err := findRollbackCommits(commitsGraph, commitsHistory, branches, "m0", WalkFirstParent); err == nil {
+		t.Errorf("Expected an error for a target outside of the commits graph")
+	}
+}
+
+func TestFindRollbackCommitsHistoryOrder(t *testing.T) {
+
+	now := time.Now()
+
+	// The deploy of m2 was cherry-picked after the one of m1, it keeps an older author date
+	commitsGraph := map[string]*HeadCommit{
+		"m1": {SHA: "m1", Parents: []string{"m0"}, GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api": {SHA: "a1", Date: now.Add(-2 * time.Hour)},
+		}},
+		"m2": {SHA: "m2", Parents: []string{"m1"}, GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api": {SHA: "a2", Date: now.Add(-3 * time.Hour)},
+		}},
+		"m3": {SHA: "m3", Parents: []string{"m2"}, GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api": {SHA: "a3", Date: now.Add(-time.Hour)},
+		}},
+	}
+	commitsHistory := map[string][]GitOpsCommit{
+		"gitops/api": {{SHA: "a3"}, {SHA: "a2"}, {SHA: "a1"}},
+	}
+
+	result, err := findRollbackCommits(commitsGraph, commitsHistory, []string{"gitops/api"}, "m2", WalkFirstParent)
+	if err != nil {
+		t.Fatalf("Failed to find rollback commits: %v", err)
+	}
+	if got := result.Anchors["gitops/api"]; got != (RollbackCommit{GitOpsCommit: "a2", HeadCommit: "m2"}) {
+		t.Errorf("Expected the newest deploy in the branch history to be the anchor, got %+v", got)
+	}
+}
+
+func TestFindDeployedBefore(t *testing.T) {
+
+	now := time.Now()
+	commitsGraph := map[string]*HeadCommit{
+		"m1": {SHA: "m1", Date: now.Add(-5 * time.Hour), GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/api": {SHA: "a1", Date: now.Add(-4 * time.Hour)},
+		}},
+		"m2": {SHA: "m2", Date: now.Add(-3 * time.Hour), GitOpsCommits: map[string]GitOpsCommit{
+			"gitops/worker": {SHA: "w2", Date: now.Add(-2 * time.Hour)},
+		}},
+		"m3": {SHA: "m3", Date: now.Add(-90 * time.Minute), GitOpsCommits: map[string]GitOpsCommit{}},
+	}
+
+	if sha, ok := findDeployedBefore(commitsGraph, now.Add(-time.Hour)); !ok || sha != "m2" {
+		t.Errorf("Expected m2 to be the latest deployed commit, got %s", sha)
+	}
+	if sha, ok := findDeployedBefore(commitsGraph, now.Add(-3*time.Hour)); !ok || sha != "m1" {
+		t.Errorf("Expected m1 to be the latest deployed commit, got %s", sha)
+	}
+	if _, ok := findDeployedBefore(commitsGraph, now.Add(-6*time.Hour)); ok {
+		t.Errorf("Expected no deployed commit before the first deploy")
+	}
+}
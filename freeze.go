@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FreezeState records what the deploy freeze changed, so that unfreeze restores exactly that
+type FreezeState struct {
+	Owner    string    `json:"owner" yaml:"owner"`
+	Repo     string    `json:"repo" yaml:"repo"`
+	FrozenAt time.Time `json:"frozenAt" yaml:"frozenAt"`
+	// Plans whose rollback froze the deploys
+	Plans []string `json:"plans" yaml:"plans"`
+	// Workflows disabled by the freeze, the ones that were already disabled are left out
+	Workflows []FrozenWorkflow `json:"workflows" yaml:"workflows"`
+	// CancelledRuns are not run again by unfreeze
+	CancelledRuns []CancelledRun `json:"cancelledRuns" yaml:"cancelledRuns"`
+}
+
+type FrozenWorkflow struct {
+	ID   int64  `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+}
+
+type CancelledRun struct {
+	ID       int64  `json:"id" yaml:"id"`
+	Branch   string `json:"branch" yaml:"branch"`
+	Workflow string `json:"workflow" yaml:"workflow"`
+	URL      string `json:"url" yaml:"url"`
+}
+
+// freeze disables the deploy workflows and cancels the pending runs of the branches of the plan before they are delivered.
+// The changes are added to the freeze state file, even if the freeze fails halfway.
+func (d *Delivery) freeze(plan *Plan) error {
+
+	state, err := loadFreezeState(d.FreezeState)
+	if errors.Is(err, os.ErrNotExist) {
+		state = &FreezeState{Owner: plan.Owner, Repo: plan.Repo, FrozenAt: time.Now().UTC()}
+	} else if err != nil {
+		return fmt.Errorf("failed to load freeze state: %w", err)
+	}
+
+	if state.Owner != plan.Owner || state.Repo != plan.Repo {
+		return fmt.Errorf("freeze state %s belongs to %s/%s, unfreeze it first", d.FreezeState, state.Owner, state.Repo)
+	}
+
+	freezeErr := freezeDeploys(context.Background(), d.client, plan, d.Workflows, state)
+
+	d.frozen = make(map[int64]bool, len(state.Workflows))
+	for _, workflow := range state.Workflows {
+		d.frozen[workflow.ID] = true
+	}
+
+	if err := saveFreezeState(d.FreezeState, state); err != nil {
+		return errors.Join(freezeErr, fmt.Errorf("failed to save freeze state: %w", err))
+	}
+	log.Printf("Freeze state saved to %s, run unfreeze to enable the deploy workflows again", d.FreezeState)
+
+	return freezeErr
+}
+
+// freezeDeploys disables the active workflows and force cancels the queued and in progress runs
+// of the branches of the plan, recording every change in the state
+func freezeDeploys(ctx context.Context, client *GithubClient, plan *Plan, workflows []string, state *FreezeState) error {
+
+	state.Plans = append(state.Plans, plan.ID)
+
+	for _, name := range workflows {
+		workflow, err := client.GetWorkflow(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if workflow.GetState() != "active" {
+			log.Printf("Workflow %s is %s, leaving it alone", workflow.GetPath(), workflow.GetState())
+			continue
+		}
+
+		if err := client.DisableWorkflow(ctx, workflow.GetID()); err != nil {
+			return fmt.Errorf("failed to disable workflow %s: %w", workflow.GetPath(), err)
+		}
+		log.Printf("Disabled workflow %s", workflow.GetPath())
+
+		state.Workflows = append(state.Workflows, FrozenWorkflow{ID: workflow.GetID(), Name: workflow.GetName(), Path: workflow.GetPath()})
+	}
+
+	for _, branch := range plan.Branches {
+		runs, err := client.ListAllWorkflowsRuns(ctx, branch.Branch)
+		if err != nil {
+			return err
+		}
+
+		for _, run := range runs {
+			if err := client.ForceCancelWorkflowRun(ctx, run.GetID()); err != nil {
+				return fmt.Errorf("failed to cancel run %s of branch %s: %w", run.GetHTMLURL(), branch.Branch, err)
+			}
+			log.Printf("Cancelled %s run %s of branch %s", run.GetStatus(), run.GetHTMLURL(), branch.Branch)
+
+			state.CancelledRuns = append(state.CancelledRuns, CancelledRun{ID: run.GetID(), Branch: branch.Branch, Workflow: run.GetName(), URL: run.GetHTMLURL()})
+		}
+	}
+
+	return nil
+}
+
+// unfreezeDeploys enables the workflows disabled by the freeze again, it returns the ones that failed
+func unfreezeDeploys(ctx context.Context, client *GithubClient, state *FreezeState) ([]FrozenWorkflow, error) {
+
+	var errs []error
+	failed := make([]FrozenWorkflow, 0)
+
+	for _, workflow := range state.Workflows {
+		if err := client.EnableWorkflow(ctx, workflow.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to enable workflow %s: %w", workflow.Path, err))
+			failed = append(failed, workflow)
+			continue
+		}
+		log.Printf("Enabled workflow %s", workflow.Path)
+	}
+
+	return failed, errors.Join(errs...)
+}
+
+// saveFreezeState writes the freeze state file, as YAML if it ends with .yaml or .yml
+func saveFreezeState(file string, state *FreezeState) error {
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := writeDocument(f, planFormat(file), state, nil); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// loadFreezeState reads a freeze state file written by saveFreezeState
+func loadFreezeState(file string) (*FreezeState, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &FreezeState{}
+	if planFormat(file) == "yaml" {
+		err = yaml.Unmarshal(data, state)
+	} else {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", file, err)
+	}
+
+	if state.Owner == "" || state.Repo == "" {
+		return nil, fmt.Errorf("freeze state %s has no repository", file)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestSaveAndLoadFreezeState(t *testing.T) {
+
+	state := &FreezeState{
+		Owner:         "trivago",
+		Repo:          "gitops",
+		FrozenAt:      time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		Plans:         []string{"20261016T120000Z-1111111"},
+		Workflows:     []FrozenWorkflow{{ID: 42, Name: "Deploy", Path: ".github/workflows/deploy.yml"}},
+		CancelledRuns: []CancelledRun{{ID: 7, Branch: "gitops/api", Workflow: "Deploy", URL: "https://github.com/trivago/gitops/actions/runs/7"}},
+	}
+
+	for _, name := range []string{"freeze.json", "freeze.yaml"} {
+		file := filepath.Join(t.TempDir(), name)
+		if err := saveFreezeState(file, state); err != nil {
+			t.Fatalf("Failed to save %s: %v", name, err)
+		}
+
+		loaded, err := loadFreezeState(file)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		if !slices.Equal(loaded.Workflows, state.Workflows) || !slices.Equal(loaded.CancelledRuns, state.CancelledRuns) || !loaded.FrozenAt.Equal(state.FrozenAt) {
+			t.Errorf("Unexpected freeze state loaded from %s: %+v", name, loaded)
+		}
+	}
+}
+
+// newTestGithubClient returns a client of the trivago/gitops repository served by the handler
+func newTestGithubClient(t *testing.T, handler http.Handler) *GithubClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to parse the test server URL: %v", err)
+	}
+	client.BaseURL = baseURL
+
+	return &GithubClient{client: client, owner: "trivago", repo: "gitops"}
+}
+
+func TestFreezeAndUnfreezeDeploys(t *testing.T) {
+
+	enabled := make([]string, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/trivago/gitops/actions/workflows/deploy.yml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 42, "name": "Deploy", "path": ".github/workflows/deploy.yml", "state": "active"}`)
+	})
+	mux.HandleFunc("GET /repos/trivago/gitops/actions/workflows/lint.yml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 43, "name": "Lint", "path": ".github/workflows/lint.yml", "state": "disabled_manually"}`)
+	})
+	mux.HandleFunc("PUT /repos/trivago/gitops/actions/workflows/42/disable", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("PUT /repos/trivago/gitops/actions/workflows/{id}/enable", func(w http.ResponseWriter, r *http.Request) {
+		enabled = append(enabled, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /repos/trivago/gitops/actions/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "queued" {
+			fmt.Fprint(w, `{"total_count": 0, "workflow_runs": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"total_count": 1, "workflow_runs": [{"id": 7, "name": "Deploy", "status": "queued", "html_url": "https://github.com/trivago/gitops/actions/runs/7"}]}`)
+	})
+	// GitHub accepts a force cancel with a 202
+	mux.HandleFunc("POST /repos/trivago/gitops/actions/runs/7/force-cancel", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{}`)
+	})
+	client := newTestGithubClient(t, mux)
+
+	plan := &Plan{ID: "fixture", Owner: "trivago", Repo: "gitops", Branches: []BranchPlan{{Branch: "gitops/api"}}}
+	state := &FreezeState{Owner: "trivago", Repo: "gitops"}
+	if err := freezeDeploys(context.Background(), client, plan, []string{"deploy.yml", "lint.yml"}, state); err != nil {
+		t.Fatalf("Failed to freeze the deploys: %v", err)
+	}
+
+	if want := []FrozenWorkflow{{ID: 42, Name: "Deploy", Path: ".github/workflows/deploy.yml"}}; !slices.Equal(state.Workflows, want) {
+		t.Errorf("Expected only the active workflow to be disabled, got %+v", state.Workflows)
+	}
+	if len(state.CancelledRuns) != 1 || state.CancelledRuns[0].ID != 7 || state.CancelledRuns[0].Branch != "gitops/api" {
+		t.Errorf("Expected the queued run to be cancelled, got %+v", state.CancelledRuns)
+	}
+
+	failed, err := unfreezeDeploys(context.Background(), client, state)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Failed to unfreeze the deploys: %v, %+v", err, failed)
+	}
+	if !slices.Equal(enabled, []string{"42"}) {
+		t.Errorf("Expected only the frozen workflow to be enabled again, got %v", enabled)
+	}
+}
+
+func TestExecutePlanFreezeFailure(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	anchor := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	head := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+	repoDir, remote := cloneBareFixture(t, f)
+
+	// The freeze state left by a rollback of another repository refuses the freeze
+	stateFile := filepath.Join(t.TempDir(), "freeze.json")
+	if err := saveFreezeState(stateFile, &FreezeState{Owner: "trivago", Repo: "other"}); err != nil {
+		t.Fatalf("Failed to save the freeze state: %v", err)
+	}
+
+	plan := &Plan{ID: "fixture", Owner: "trivago", Repo: "gitops", Strategy: StrategyRestore}
+	plan.Branches = []BranchPlan{{
+		Branch:  "gitops/api",
+		Head:    head,
+		Anchor:  RollbackCommit{GitOpsCommit: anchor},
+		Commits: []GitOpsCommit{{SHA: head, Message: "Deploy v2", Paths: []string{path}}},
+	}}
+	delivery := &Delivery{Mode: DeliverPush, Freeze: true, FreezeState: stateFile}
+
+	results := executePlan(plan, repoDir, OnConflictSkip, delivery)
+	if len(results) != 1 || results[0].Status != StatusFailed || !strings.HasPrefix(results[0].Error, "deploys could not be frozen") {
+		t.Fatalf("Expected the branch to fail on the freeze, got %+v", results)
+	}
+
+	if got := strings.TrimSpace(gitOutput(t, remote, "rev-parse", "gitops/api")); got != head {
+		t.Errorf("Expected gitops/api not to be pushed, got %s", got)
+	}
+	if got := gitOutput(t, repoDir, "branch", "--list", "gitops/api"); got != "" {
+		t.Errorf("Expected gitops/api not to be checked out in the clone, got %q", got)
+	}
+}
+
+func TestNewDeliveryFreezeWait(t *testing.T) {
+
+	t.Setenv("GITHUB_TOKEN", "fixture")
+
+	opts := &Options{Deliver: DeliverPush, Freeze: true, Wait: true}
+	delivery, err := newDelivery(opts, "trivago", "gitops")
+	if err != nil {
+		t.Fatalf("Expected -wait to be accepted with -freeze: %v", err)
+	}
+	if !delivery.Freeze || !delivery.Wait || delivery.client == nil {
+		t.Errorf("Expected the freeze and the wait of a pushed delivery, got %+v", delivery)
+	}
+
+	// Delivered locally, nothing is frozen nor waited for
+	opts.Deliver = DeliverLocal
+	delivery, err = newDelivery(opts, "trivago", "gitops")
+	if err != nil {
+		t.Fatalf("Unexpected error of a local delivery: %v", err)
+	}
+	if delivery.Freeze || delivery.Wait {
+		t.Errorf("Expected the freeze and the wait to be skipped, got %+v", delivery)
+	}
+}
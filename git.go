@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -104,16 +105,44 @@ func cloneRepositoryCLI(owner, repoName string) (string, error) {
 
 }
 
-// revertFromCommitCLI reverts multiple commits in a single command
-func revertFromCommitCLI(repoDir string, branch string, commits []string, force bool, pushMode bool) error {
-	// Check if commits slice is empty
-	if len(commits) == 0 {
-		return fmt.Errorf("no commits provided to revert")
+// gitTimeout bounds the git commands run on a branch worktree
+const gitTimeout = 10 * time.Minute
+
+// runGitCLI runs a git command in a directory with interaction and editor prompts disabled and returns its output.
+// The stdin, if any, is fed to the command.
+func runGitCLI(ctx context.Context, dir string, stdin []byte, args ...string) ([]byte, error) {
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_MERGE_AUTOEDIT=no",
+		"GIT_EDITOR=true",
+	)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
 	}
 
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("git %s timed out after %v: %s%s", args[0], gitTimeout, output, stderr.Bytes())
+	}
+	if err != nil {
+		return output, fmt.Errorf("git %s failed: %s%s, %w", args[0], output, stderr.Bytes(), err)
+	}
+
+	return output, nil
+}
+
+// newBranchWorktree creates a worktree of the branch in a temporary directory.
+// The returned function removes it, so that the branch can be checked out again.
+func newBranchWorktree(repoDir string, branch string) (string, func(), error) {
+
 	err := configureGit()
 	if err != nil {
-		return fmt.Errorf("failed to configure git: %w", err)
+		return "", nil, fmt.Errorf("failed to configure git: %w", err)
 	}
 
 	branchDirName := strings.ReplaceAll(branch, "/", "-")
@@ -121,72 +150,328 @@ func revertFromCommitCLI(repoDir string, branch string, commits []string, force
 	// Create a temporary directory to clone the repository
 	branchRootDir, err := os.MkdirTemp("", fmt.Sprintf("git-revert-%s-*-*", branchDirName))
 	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
 	}
-	defer os.RemoveAll(branchRootDir) // Clean up when we're done
 
 	// Create a worktree for the branch
 	err = createWorktree(repoDir, branch, branchRootDir)
 	if err != nil {
-		return fmt.Errorf("failed to create worktree: %w", err)
+		os.RemoveAll(branchRootDir)
+		return "", nil, fmt.Errorf("failed to create worktree: %w", err)
 	}
 
-	// Add author information for git commands
-	// Execute git revert command using CLI for all commits at once
-	revertArgs := []string{"revert", "--no-edit"}
+	remove := func() {
+		worktreeLock.Lock()
+		defer worktreeLock.Unlock()
 
-	if force {
-		revertArgs = append(revertArgs, "--no-gpg-sign")
+		if _, err := runGitCLI(context.Background(), repoDir, nil, "worktree", "remove", "--force", branchRootDir); err != nil {
+			log.Printf("Failed to remove the worktree of branch %s: %v", branch, err)
+		}
+		os.RemoveAll(branchRootDir)
+	}
+
+	return branchRootDir, remove, nil
+}
+
+// ConflictError is returned when reverting a commit conflicts with the later changes of the branch.
+// The revert is aborted and the branch left at its head.
+type ConflictError struct {
+	Branch string   `json:"branch" yaml:"branch"`
+	Commit string   `json:"commit" yaml:"commit"`
+	Files  []string `json:"files" yaml:"files"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("reverting commit %s conflicts on branch %s in %s", e.Commit, e.Branch, strings.Join(e.Files, ", "))
+}
+
+// unmergedFiles lists the files left with conflicts in a worktree
+func unmergedFiles(ctx context.Context, branchRootDir string) ([]string, error) {
+
+	output, err := runGitCLI(ctx, branchRootDir, nil, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+// pushRefCLI pushes a local branch of the clone to a branch of the remote repository
+func pushRefCLI(repoDir string, branch string, remoteBranch string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	refspec := fmt.Sprintf("refs/heads/%s:refs/heads/%s", branch, remoteBranch)
+	if _, err := runGitCLI(ctx, repoDir, nil, "push", "origin", refspec); err != nil {
+		return fmt.Errorf("failed to push changes: %w", err)
 	}
 
-	// Add all commits to revert in a single command (from newest to oldest)
-	revertArgs = append(revertArgs, commits...)
+	return nil
+}
+
+// atomicRef is a local branch of the clone pushed by an atomic push
+type atomicRef struct {
+	Branch       string
+	RemoteBranch string
+	// Expected is the commit the remote branch must still point to, empty if it must not exist
+	Expected string
+}
+
+// pushAtomicCLI pushes local branches of the clone in a single atomic push, either every branch is updated or none.
+// The push is refused if a remote branch doesn't point to the expected commit anymore.
+func pushAtomicCLI(repoDir string, refs []atomicRef) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	pushArgs := []string{"push", "--atomic", "--porcelain", "origin"}
+	for _, ref := range refs {
+		pushArgs = append(pushArgs, fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", ref.RemoteBranch, ref.Expected))
+	}
+	for _, ref := range refs {
+		pushArgs = append(pushArgs, fmt.Sprintf("refs/heads/%s:refs/heads/%s", ref.Branch, ref.RemoteBranch))
+	}
+
+	if _, err := runGitCLI(ctx, repoDir, nil, pushArgs...); err != nil {
+		return fmt.Errorf("failed to push changes atomically: %w", err)
+	}
+
+	return nil
+}
+
+// resetBranchCLI points a local branch of the clone back to a commit
+func resetBranchCLI(repoDir string, branch string, sha string) error {
+
+	if _, err := runGitCLI(context.Background(), repoDir, nil, "update-ref", "refs/heads/"+branch, sha); err != nil {
+		return fmt.Errorf("failed to reset branch %s to %s: %w", branch, sha, err)
+	}
+
+	return nil
+}
+
+// localBranchHead returns the commit a local branch of the clone points to
+func localBranchHead(repoDir string, branch string) (string, error) {
+
+	output, err := runGitCLI(context.Background(), repoDir, nil, "rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/heads/%s^{commit}", branch))
+	if err != nil {
+		return "", fmt.Errorf("branch %s not found in the clone: %w", branch, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// revertFromCommitCLI reverts the commits one by one from newest to oldest, on the local branch of the clone.
+// Every revert commit gets the trailer identifying the rollback.
+func revertFromCommitCLI(repoDir string, branch string, commits []string, trailer string, force bool) error {
+	// Check if commits slice is empty
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits provided to revert")
+	}
+
+	branchRootDir, removeWorktree, err := newBranchWorktree(repoDir, branch)
+	if err != nil {
+		return err
+	}
+	defer removeWorktree() // Clean up when we're done
 
 	// Run revert with a timeout and disable any interaction/editor prompts
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
 	defer cancel()
 
-	revertCmd := exec.CommandContext(ctx, "git", revertArgs...)
-	log.Printf("Revert Command: %v", revertCmd.String())
-	revertCmd.Dir = branchRootDir
-	revertCmd.Env = append(os.Environ(),
-		"GIT_TERMINAL_PROMPT=0",
-		"GIT_MERGE_AUTOEDIT=no",
-		"GIT_EDITOR=true",
-	)
-	revertOutput, err := revertCmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("git revert timed out after 10m: %s", revertOutput)
+	// A conflict resets the branch to its head, dropping the reverts already committed
+	head, err := runGitCLI(ctx, branchRootDir, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	for _, commit := range commits {
+		log.Printf("Revert Command: git revert --no-commit %s", commit)
+		if _, err := runGitCLI(ctx, branchRootDir, nil, "revert", "--no-commit", commit); err != nil {
+			files, unmergedErr := unmergedFiles(ctx, branchRootDir)
+			if unmergedErr != nil || len(files) == 0 {
+				return fmt.Errorf("failed to revert commit %s: %w", commit, err)
+			}
+
+			if _, err := runGitCLI(ctx, branchRootDir, nil, "revert", "--abort"); err != nil {
+				return fmt.Errorf("failed to abort the conflicting revert: %w", err)
+			}
+			if _, err := runGitCLI(ctx, branchRootDir, nil, "reset", "--hard", "--quiet", strings.TrimSpace(string(head))); err != nil {
+				return fmt.Errorf("failed to abort the conflicting revert: %w", err)
+			}
+
+			return &ConflictError{Branch: branch, Commit: commit, Files: files}
+		}
+
+		// The message prepared by the revert, with the trailer
+		commitArgs := []string{"commit", "--no-edit", "--trailer", trailer}
+		if force {
+			commitArgs = append(commitArgs, "--no-gpg-sign")
+		}
+		if _, err := runGitCLI(ctx, branchRootDir, nil, commitArgs...); err != nil {
+			return fmt.Errorf("failed to commit the revert of %s: %w", commit, err)
+		}
 	}
 
+	return nil
+}
+
+// revertPathsCLI reverts the changes of the commits under their analyzed paths only, newest to oldest,
+// one revert commit with the trailer per commit. The changes of the commits outside of these paths are left alone.
+func revertPathsCLI(repoDir string, branch string, commits []GitOpsCommit, trailer string, force bool) error {
+
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits provided to revert")
+	}
+
+	branchRootDir, removeWorktree, err := newBranchWorktree(repoDir, branch)
 	if err != nil {
-		return fmt.Errorf("failed to revert commits: %s, %w", revertOutput, err)
+		return err
 	}
+	defer removeWorktree()
 
-	// Make sure all new files are added
-	gitAddCmd := exec.CommandContext(ctx, "git", "add", ".")
-	gitAddCmd.Dir = branchRootDir
-	gitAddOutput, err := gitAddCmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("git add timed out after 10m: %s", gitAddOutput)
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	// A conflict resets the branch to its head, dropping the reverts already committed
+	head, err := runGitCLI(ctx, branchRootDir, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	for _, commit := range commits {
+		if len(commit.Paths) == 0 {
+			return fmt.Errorf("commit %s has no analyzed paths to revert", commit.SHA)
+		}
+
+		// The patch of the commit against its first parent, restricted to the paths
+		diffArgs := append([]string{"diff-tree", "-p", "--binary", "--full-index", commit.SHA + "^", commit.SHA, "--"}, commit.Paths...)
+		patch, err := runGitCLI(ctx, branchRootDir, nil, diffArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to diff commit %s: %w", commit.SHA, err)
+		}
+
+		if len(patch) == 0 {
+			log.Printf("Commit %s changes nothing under %s on branch %s, nothing to revert", commit.SHA, strings.Join(commit.Paths, ", "), branch)
+			continue
+		}
+
+		log.Printf("Reverting commit %s under %s on branch %s", commit.SHA, strings.Join(commit.Paths, ", "), branch)
+		if _, err := runGitCLI(ctx, branchRootDir, patch, "apply", "-R", "--index", "--3way"); err != nil {
+			files, unmergedErr := unmergedFiles(ctx, branchRootDir)
+			if unmergedErr != nil || len(files) == 0 {
+				return fmt.Errorf("failed to revert commit %s: %w", commit.SHA, err)
+			}
+
+			if _, err := runGitCLI(ctx, branchRootDir, nil, "reset", "--hard", "--quiet", strings.TrimSpace(string(head))); err != nil {
+				return fmt.Errorf("failed to abort the conflicting revert: %w", err)
+			}
+
+			return &ConflictError{Branch: branch, Commit: commit.SHA, Files: files}
+		}
+
+		message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts the changes of commit %s under %s.", firstLine(commit.Message), commit.SHA, strings.Join(commit.Paths, ", "))
+		if len(commit.LeftAlone) > 0 {
+			message += fmt.Sprintf("\nThe changes to %s are left alone.", strings.Join(commit.LeftAlone, ", "))
+		}
+
+		commitArgs := []string{"commit", "-m", message, "--trailer", trailer}
+		if force {
+			commitArgs = append(commitArgs, "--no-gpg-sign")
+		}
+		if _, err := runGitCLI(ctx, branchRootDir, nil, commitArgs...); err != nil {
+			return fmt.Errorf("failed to commit the revert of %s: %w", commit.SHA, err)
+		}
+	}
+
+	return nil
+}
+
+// restorePathsCLI makes the paths of the branch identical to their state at the anchor gitops commit in a single commit,
+// whose message lists the commits it supersedes, newest to oldest, and ends with the trailer
+func restorePathsCLI(repoDir string, branch string, anchor RollbackCommit, paths []string, commits []GitOpsCommit, trailer string, force bool) error {
+
+	if len(paths) == 0 {
+		return fmt.Errorf("no paths provided to restore")
 	}
+
+	branchRootDir, removeWorktree, err := newBranchWorktree(repoDir, branch)
 	if err != nil {
-		return fmt.Errorf("failed to add files to index: %s, %w", gitAddOutput, err)
+		return err
 	}
+	defer removeWorktree()
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
 
-	// Push the changes back using go-git
-	if !pushMode {
-		log.Printf("Skipping push of changes to remote repository, pushMode is false")
+	// The patch from the head to the anchor also removes the files added since the anchor
+	diffArgs := append([]string{"diff-tree", "-p", "--binary", "--full-index", "HEAD", anchor.GitOpsCommit, "--"}, paths...)
+	patch, err := runGitCLI(ctx, branchRootDir, nil, diffArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to diff the head of branch %s with anchor %s: %w", branch, anchor.GitOpsCommit, err)
+	}
+
+	if len(patch) == 0 {
+		log.Printf("Branch %s is already at the state of anchor %s under %s, nothing to restore", branch, anchor.GitOpsCommit, strings.Join(paths, ", "))
 		return nil
 	}
-	pushCmd := exec.CommandContext(ctx, "git", "push", "origin", branch)
-	pushCmd.Dir = branchRootDir
-	pushOutput, err := pushCmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("git push timed out after 10m: %s", pushOutput)
+
+	log.Printf("Restoring %s on branch %s to anchor %s", strings.Join(paths, ", "), branch, anchor.GitOpsCommit)
+	if _, err := runGitCLI(ctx, branchRootDir, patch, "apply", "--index"); err != nil {
+		return fmt.Errorf("failed to restore anchor %s: %w", anchor.GitOpsCommit, err)
 	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "Restore %s to %.12s\n\n", strings.Join(paths, ", "), anchor.GitOpsCommit)
+	fmt.Fprintf(&message, "This restores %s to their state at commit %s, deploying %s.\n", strings.Join(paths, ", "), anchor.GitOpsCommit, anchor.HeadCommit)
+	fmt.Fprintf(&message, "\nSuperseded commits:\n")
+	for _, commit := range commits {
+		fmt.Fprintf(&message, "- %.12s %s\n", commit.SHA, firstLine(commit.Message))
+	}
+
+	commitArgs := []string{"commit", "-m", message.String(), "--trailer", trailer}
+	if force {
+		commitArgs = append(commitArgs, "--no-gpg-sign")
+	}
+	if _, err := runGitCLI(ctx, branchRootDir, nil, commitArgs...); err != nil {
+		return fmt.Errorf("failed to commit the restore of anchor %s: %w", anchor.GitOpsCommit, err)
+	}
+
+	return nil
+}
+
+// remoteBranchHead returns the commit a remote-tracking branch points to in the clone
+func remoteBranchHead(repoDir string, branch string) (string, error) {
+
+	revParseCmd := exec.Command("git", "rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/remotes/origin/%s^{commit}", branch))
+	revParseCmd.Dir = repoDir
+	output, err := revParseCmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to push changes: %s, %w", pushOutput, err)
+		return "", fmt.Errorf("branch %s not found in the clone: %w", branch, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// verifyBranchHeads checks that every branch of the plan still points to the head seen when the plan was computed
+func verifyBranchHeads(repoDir string, plan *Plan) error {
+
+	moved := make([]string, 0)
+
+	for _, branch := range plan.Branches {
+		head, err := remoteBranchHead(repoDir, branch.Branch)
+		if err != nil {
+			return err
+		}
+
+		if head != branch.Head {
+			log.Printf("Branch %s moved since the plan was computed: expected %s, found %s", branch.Branch, branch.Head, head)
+			moved = append(moved, branch.Branch)
+		}
+	}
+
+	if len(moved) > 0 {
+		return fmt.Errorf("branches moved since the plan was computed: %s", strings.Join(moved, ", "))
 	}
 
 	return nil
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -52,3 +57,190 @@ func TestCreateWorktree(t *testing.T) {
 	}
 	t.Logf("Worktree created in %v", time.Since(start))
 }
+
+// cloneFixture clones a fixture repository the same way cloneRepositoryCLI clones GitHub, with master checked out
+func cloneFixture(t *testing.T, f *fixtureRepo) string {
+	t.Helper()
+
+	repoDir := filepath.Join(t.TempDir(), "clone")
+	output, err := exec.Command("git", "clone", "--quiet", "--branch", "master", f.dir, repoDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to clone fixture repository: %v, output: %s", err, output)
+	}
+
+	return repoDir
+}
+
+func TestVerifyBranchHeads(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	head := f.commit("gitops/api", map[string]string{"manifests/api/prod/deployment.yaml": "v1"}, "Deploy v1")
+	repoDir := cloneFixture(t, f)
+
+	plan := &Plan{
+		ID:       "fixture",
+		Branches: []BranchPlan{{Branch: "gitops/api", Head: head}},
+	}
+
+	if err := verifyBranchHeads(repoDir, plan); err != nil {
+		t.Fatalf("Expected branch heads to match: %v", err)
+	}
+
+	plan.Branches[0].Head = f.base.String()
+	if err := verifyBranchHeads(repoDir, plan); err == nil {
+		t.Fatalf("Expected an error for a branch that moved")
+	}
+
+	plan.Branches[0].Branch = "gitops/missing"
+	if err := verifyBranchHeads(repoDir, plan); err == nil {
+		t.Fatalf("Expected an error for a missing branch")
+	}
+}
+
+// setGitIdentity sets the identity of the commits made by the git CLI
+func setGitIdentity(t *testing.T) {
+	t.Helper()
+
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "Fixture")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "fixture@example.com")
+	}
+}
+
+// gitOutput runs a git command in a clone and returns its output
+func gitOutput(t *testing.T, repoDir string, args ...string) string {
+	t.Helper()
+
+	output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to run git %s: %v, output: %s", strings.Join(args, " "), err, output)
+	}
+
+	return string(output)
+}
+
+func TestRevertFromCommitCLI(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	v2 := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+	v3 := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v3"}, "Deploy v3")
+	repoDir := cloneFixture(t, f)
+
+	if err := revertFromCommitCLI(repoDir, "gitops/api", []string{v3, v2}, "Rollback-Id: test", true); err != nil {
+		t.Fatalf("Failed to revert the commits: %v", err)
+	}
+
+	if got := gitOutput(t, repoDir, "show", "gitops/api:"+path+"/deployment.yaml"); got != "v1" {
+		t.Errorf("Expected the path to be reverted to v1, got %q", got)
+	}
+
+	// One revert commit per commit, each with the trailer
+	trailers := gitOutput(t, repoDir, "log", "-2", "--format=%(trailers:key=Rollback-Id,valueonly)%s", "gitops/api")
+	if trailers != "test\nRevert \"Deploy v2\"\ntest\nRevert \"Deploy v3\"\n" {
+		t.Errorf("Expected two revert commits with the trailer, got %q", trailers)
+	}
+}
+
+func TestRevertPathsCLI(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1", "manifests/worker/prod/deployment.yaml": "v1"}, "Deploy v1")
+	bad := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2", "manifests/worker/prod/deployment.yaml": "v2"}, "Deploy v2")
+	repoDir := cloneFixture(t, f)
+
+	commits := []GitOpsCommit{{SHA: bad, Message: "Deploy v2", Paths: []string{path}, LeftAlone: []string{"manifests/worker/prod/deployment.yaml"}}}
+	if err := revertPathsCLI(repoDir, "gitops/api", commits, "Rollback-Id: test", true); err != nil {
+		t.Fatalf("Failed to revert the paths: %v", err)
+	}
+
+	if got := gitOutput(t, repoDir, "show", "gitops/api:"+path+"/deployment.yaml"); got != "v1" {
+		t.Errorf("Expected the path to be reverted to v1, got %q", got)
+	}
+	if got := gitOutput(t, repoDir, "show", "gitops/api:manifests/worker/prod/deployment.yaml"); got != "v2" {
+		t.Errorf("Expected the file outside of the path to be left alone at v2, got %q", got)
+	}
+}
+
+func TestRestorePathsCLI(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	anchor := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1", "manifests/worker/prod/deployment.yaml": "v1"}, "Deploy v1")
+	v2 := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2", path + "/canary.yaml": "v2"}, "Deploy v2")
+	v3 := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v3", "manifests/worker/prod/deployment.yaml": "v3"}, "Deploy v3")
+	repoDir := cloneFixture(t, f)
+
+	commits := []GitOpsCommit{
+		{SHA: v3, Message: "Deploy v3", Paths: []string{path}},
+		{SHA: v2, Message: "Deploy v2", Paths: []string{path}},
+	}
+	if err := restorePathsCLI(repoDir, "gitops/api", RollbackCommit{GitOpsCommit: anchor}, commitPaths(commits), commits, "Rollback-Id: test", true); err != nil {
+		t.Fatalf("Failed to restore the paths: %v", err)
+	}
+
+	if got := gitOutput(t, repoDir, "ls-tree", "-r", "--name-only", "gitops/api", "--", path); got != path+"/deployment.yaml\n" {
+		t.Errorf("Expected the files added since the anchor to be removed, got %q", got)
+	}
+	if got := gitOutput(t, repoDir, "show", "gitops/api:"+path+"/deployment.yaml"); got != "v1" {
+		t.Errorf("Expected the path to be restored to v1, got %q", got)
+	}
+	if got := gitOutput(t, repoDir, "show", "gitops/api:manifests/worker/prod/deployment.yaml"); got != "v3" {
+		t.Errorf("Expected the file outside of the path to be left alone at v3, got %q", got)
+	}
+
+	if parent := strings.TrimSpace(gitOutput(t, repoDir, "rev-parse", "gitops/api^")); parent != v3 {
+		t.Errorf("Expected a single restore commit on top of %s, got parent %s", v3, parent)
+	}
+	message := gitOutput(t, repoDir, "log", "-1", "--format=%B", "gitops/api")
+	if !strings.Contains(message, v3[:12]+" Deploy v3") || !strings.Contains(message, v2[:12]+" Deploy v2") {
+		t.Errorf("Expected the restore commit to list the superseded commits, got %q", message)
+	}
+}
+
+func TestRevertConflict(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	bad := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+	head := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v3"}, "Deploy v3")
+	repoDir := cloneFixture(t, f)
+
+	// Reverting v2 without v3 conflicts, both with git revert and with a path-scoped revert
+	reverts := map[string]func() error{
+		"revert": func() error {
+			return revertFromCommitCLI(repoDir, "gitops/api", []string{bad}, "Rollback-Id: test", true)
+		},
+		"path-scoped revert": func() error {
+			return revertPathsCLI(repoDir, "gitops/api", []GitOpsCommit{{SHA: bad, Message: "Deploy v2", Paths: []string{path}}}, "Rollback-Id: test", true)
+		},
+	}
+
+	for name, revert := range reverts {
+		var conflict *ConflictError
+		if err := revert(); !errors.As(err, &conflict) {
+			t.Fatalf("%s: expected a conflict error, got %v", name, err)
+		}
+
+		if conflict.Commit != bad || !slices.Equal(conflict.Files, []string{path + "/deployment.yaml"}) {
+			t.Errorf("%s: unexpected conflict %+v", name, conflict)
+		}
+
+		if got := strings.TrimSpace(gitOutput(t, repoDir, "rev-parse", "gitops/api")); got != head {
+			t.Errorf("%s: expected the branch to be left at its head %s, got %s", name, head, got)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v71/github"
@@ -60,12 +63,25 @@ func (c *GithubClient) ListCommitsAfterCommit(ctx context.Context, branch, commi
 	return desiredCommits, nil
 }
 
-// ListCommitsSince list all commits since a specific time
-func (c *GithubClient) ListCommitsSince(ctx context.Context, since time.Time, branch string) ([]*github.RepositoryCommit, error) {
+// ListCommitsSince list all commits in the window
+func (c *GithubClient) ListCommitsSince(ctx context.Context, window Window, branch string) ([]*github.RepositoryCommit, error) {
+	return c.listCommits(ctx, window, branch, "")
+}
+
+// ListCommitsSinceOnPath lists all commits in the window on a specific path
+func (c *GithubClient) ListCommitsSinceOnPath(ctx context.Context, window Window, branch, path string) ([]*github.RepositoryCommit, error) {
+	return c.listCommits(ctx, window, branch, path)
+}
+
+func (c *GithubClient) listCommits(ctx context.Context, window Window, branch, path string) ([]*github.RepositoryCommit, error) {
 	opts := &github.CommitsListOptions{
-		Since:       since,
 		ListOptions: github.ListOptions{PerPage: 100},
 		SHA:         branch,
+		Path:        path,
+	}
+
+	if window.Since != nil {
+		opts.Since = *window.Since
 	}
 
 	allCommits := make([]*github.RepositoryCommit, 0)
@@ -79,6 +95,11 @@ func (c *GithubClient) ListCommitsSince(ctx context.Context, since time.Time, br
 		// This was the bug - we were appending commits to itself instead of to allCommits
 		allCommits = append(allCommits, pageCommits...)
 
+		if window.full(len(allCommits)) {
+			allCommits = allCommits[:window.Commits]
+			break
+		}
+
 		if resp.NextPage == 0 {
 			break
 		}
@@ -89,25 +110,25 @@ func (c *GithubClient) ListCommitsSince(ctx context.Context, since time.Time, br
 	return allCommits, nil
 }
 
-// ListCommitsSinceOnPath lists all commits since a specific time on a specific path
-func (c *GithubClient) ListCommitsSinceOnPath(ctx context.Context, since time.Time, branch, path string) ([]*github.RepositoryCommit, error) {
-	opts := &github.CommitsListOptions{
-		Since:       since,
-		ListOptions: github.ListOptions{PerPage: 100},
-		SHA:         branch,
-		Path:        path,
-	}
+// ListCommitFiles lists the files a commit changed compared to its first parent
+func (c *GithubClient) ListCommitFiles(ctx context.Context, sha string) ([]string, error) {
 
-	allCommits := make([]*github.RepositoryCommit, 0)
+	opts := &github.ListOptions{PerPage: 100}
+	files := make([]string, 0)
 
 	for {
-		pageCommits, resp, err := c.client.Repositories.ListCommits(ctx, c.owner, c.repo, opts)
+		commit, resp, err := c.client.Repositories.GetCommit(ctx, c.owner, c.repo, sha, opts)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to get commit %s: %w", sha, err)
 		}
 
-		// This was the bug - we were appending commits to itself instead of to allCommits
-		allCommits = append(allCommits, pageCommits...)
+		for _, file := range commit.Files {
+			files = append(files, file.GetFilename())
+			// A rename also changes the file it was renamed from
+			if previous := file.GetPreviousFilename(); previous != "" {
+				files = append(files, previous)
+			}
+		}
 
 		if resp.NextPage == 0 {
 			break
@@ -116,10 +137,77 @@ func (c *GithubClient) ListCommitsSinceOnPath(ctx context.Context, since time.Ti
 		opts.Page = resp.NextPage
 	}
 
-	return allCommits, nil
+	return files, nil
+}
+
+// ListPaths lists the files and directories of the tree at the head of a branch
+func (c *GithubClient) ListPaths(ctx context.Context, branch string) ([]string, error) {
+
+	tree, _, err := c.client.Git.GetTree(ctx, c.owner, c.repo, branch, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.GetTruncated() {
+		log.Printf("Tree of branch %s is too large and was truncated by GitHub, some paths may be missing", branch)
+	}
+
+	paths := make([]string, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		paths = append(paths, entry.GetPath())
+	}
+
+	return paths, nil
+}
+
+// ResolveCommit resolves a commit SHA, short or not, a tag (tags/v1.0.0) or a branch to its full commit SHA
+func (c *GithubClient) ResolveCommit(ctx context.Context, ref string) (string, error) {
+
+	sha, _, err := c.client.Repositories.GetCommitSHA1(ctx, c.owner, c.repo, ref, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+
+	return sha, nil
+}
+
+// PullRequestMergeCommit returns the commit a pull request was merged with
+func (c *GithubClient) PullRequestMergeCommit(ctx context.Context, number int) (string, error) {
+
+	pr, _, err := c.client.PullRequests.Get(ctx, c.owner, c.repo, number)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pull request #%d: %w", number, err)
+	}
+
+	if !pr.GetMerged() {
+		return "", fmt.Errorf("pull request #%d is not merged", number)
+	}
+
+	if base := pr.GetBase().GetRef(); base != "master" {
+		return "", fmt.Errorf("pull request #%d was merged into %s, not master", number, base)
+	}
+
+	return pr.GetMergeCommitSHA(), nil
+}
+
+// ReleaseCommit returns the commit the tag of a release points to, latest selects the latest release
+func (c *GithubClient) ReleaseCommit(ctx context.Context, release string) (string, error) {
+
+	var repositoryRelease *github.RepositoryRelease
+	var err error
+	if release == "latest" {
+		repositoryRelease, _, err = c.client.Repositories.GetLatestRelease(ctx, c.owner, c.repo)
+	} else {
+		repositoryRelease, _, err = c.client.Repositories.GetReleaseByTag(ctx, c.owner, c.repo, release)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get release %s: %w", release, err)
+	}
+
+	return c.ResolveCommit(ctx, "tags/"+repositoryRelease.GetTagName())
 }
 
-// listAllWorkflowsRuns lists all workflow runs for a given repository
+// ListAllWorkflowsRuns lists the workflow runs of a branch that are queued, waiting or in progress
 func (c *GithubClient) ListAllWorkflowsRuns(ctx context.Context, branch string) ([]*github.WorkflowRun, error) {
 
 	statuses := []string{
@@ -133,15 +221,15 @@ func (c *GithubClient) ListAllWorkflowsRuns(ctx context.Context, branch string)
 
 	for _, status := range statuses {
 
-		for {
-			opts := &github.ListWorkflowRunsOptions{
-				Status: status,
-				Branch: branch,
-				ListOptions: github.ListOptions{
-					PerPage: 100,
-				},
-			}
+		opts := &github.ListWorkflowRunsOptions{
+			Status: status,
+			Branch: branch,
+			ListOptions: github.ListOptions{
+				PerPage: 100,
+			},
+		}
 
+		for {
 			workflowRuns, resp, err := c.client.Actions.ListRepositoryWorkflowRuns(
 				ctx,
 				c.owner,
@@ -150,8 +238,7 @@ func (c *GithubClient) ListAllWorkflowsRuns(ctx context.Context, branch string)
 			)
 
 			if err != nil {
-				log.Printf("Error listing workflow runs for status %s: %v", status, err)
-				continue
+				return nil, fmt.Errorf("failed to list the %s workflow runs of branch %s: %w", status, branch, err)
 			}
 
 			workflowsRunList = append(workflowsRunList, workflowRuns.WorkflowRuns...)
@@ -170,27 +257,70 @@ func (c *GithubClient) ListAllWorkflowsRuns(ctx context.Context, branch string)
 
 }
 
+// ListCommitWorkflowRuns lists the workflow runs of a branch triggered by its head commit
+func (c *GithubClient) ListCommitWorkflowRuns(ctx context.Context, branch, sha string) ([]*github.WorkflowRun, error) {
+
+	opts := &github.ListWorkflowRunsOptions{
+		Branch:  branch,
+		HeadSHA: sha,
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	workflowsRunList := make([]*github.WorkflowRun, 0)
+	for {
+		workflowRuns, resp, err := c.client.Actions.ListRepositoryWorkflowRuns(ctx, c.owner, c.repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the workflow runs of commit %s on branch %s: %w", sha, branch, err)
+		}
+
+		workflowsRunList = append(workflowsRunList, workflowRuns.WorkflowRuns...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return workflowsRunList, nil
+}
+
 // ForceCancelWorkflowRun force cancels a workflow run
 func (c *GithubClient) ForceCancelWorkflowRun(ctx context.Context, workflowRunID int64) error {
 
 	// use /force-cancel endpoint to cancel a workflow run
-	url := fmt.Sprintf("https://github.com/%s/%s/actions/runs/%d/force-cancel", c.owner, c.repo, workflowRunID)
+	url := fmt.Sprintf("repos/%s/%s/actions/runs/%d/force-cancel", c.owner, c.repo, workflowRunID)
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	req, err := c.client.NewRequest("POST", url, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.client.Do(ctx, req, nil)
-	if err != nil {
+	// GitHub accepts the cancel with a 202, which go-github reports as an AcceptedError
+	_, err = c.client.Do(ctx, req, nil)
+	if err != nil && !errors.As(err, new(*github.AcceptedError)) {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("failed to force cancel workflow run: %s", resp.Status)
+	return nil
+}
+
+// GetWorkflow returns a workflow by its ID or its file name
+func (c *GithubClient) GetWorkflow(ctx context.Context, workflow string) (*github.Workflow, error) {
+
+	var result *github.Workflow
+	var err error
+	if id, parseErr := strconv.ParseInt(workflow, 10, 64); parseErr == nil {
+		result, _, err = c.client.Actions.GetWorkflowByID(ctx, c.owner, c.repo, id)
+	} else {
+		result, _, err = c.client.Actions.GetWorkflowByFileName(ctx, c.owner, c.repo, workflow)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow %s: %w", workflow, err)
 	}
 
-	return nil
+	return result, nil
 }
 
 // DisableWorkflow disables a workflow
@@ -208,11 +338,26 @@ func (c *GithubClient) DisableWorkflow(ctx context.Context, workflowID int64) er
 	return nil
 }
 
-// ListBranches lists all branches
-func (c *GithubClient) ListBranches(ctx context.Context, filter func(string) bool, protected bool) ([]*github.Branch, error) {
+// EnableWorkflow enables a workflow
+func (c *GithubClient) EnableWorkflow(ctx context.Context, workflowID int64) error {
+
+	resp, err := c.client.Actions.EnableWorkflowByID(ctx, c.owner, c.repo, workflowID)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to enable workflow: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// ListBranches lists all branches, only the protected or unprotected ones if protected is not nil
+func (c *GithubClient) ListBranches(ctx context.Context, filter func(string) bool, protected *bool) ([]*github.Branch, error) {
 
 	opts := &github.BranchListOptions{
-		Protected: &protected,
+		Protected: protected,
 		ListOptions: github.ListOptions{
 			PerPage: 100,
 		},
@@ -241,3 +386,118 @@ func (c *GithubClient) ListBranches(ctx context.Context, filter func(string) boo
 
 	return gitopsBranches, nil
 }
+
+// RequiredChecks lists the status checks required by the protection of a branch
+func (c *GithubClient) RequiredChecks(ctx context.Context, branch string) ([]string, error) {
+
+	requiredChecks, resp, err := c.client.Repositories.GetRequiredStatusChecks(ctx, c.owner, c.repo, branch)
+	if errors.Is(err, github.ErrBranchNotProtected) || (resp != nil && resp.StatusCode == http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]string, 0)
+	for _, check := range requiredChecks.GetChecks() {
+		checks = append(checks, check.Context)
+	}
+	if len(checks) == 0 {
+		checks = append(checks, requiredChecks.GetContexts()...)
+	}
+
+	return checks, nil
+}
+
+// PullRequestOptions are the optional settings of a rollback pull request
+type PullRequestOptions struct {
+	Reviewers []string
+	Labels    []string
+	AutoMerge bool
+}
+
+// CreatePullRequest opens a pull request of head into base, then requests the reviewers, adds the labels
+// and enables auto-merge. The pull request is returned even if one of these follow-up steps failed.
+func (c *GithubClient) CreatePullRequest(ctx context.Context, head, base, title, body string, opts PullRequestOptions) (*github.PullRequest, error) {
+
+	pr, _, err := c.client.PullRequests.Create(ctx, c.owner, c.repo, &github.NewPullRequest{
+		Title: github.Ptr(title),
+		Head:  github.Ptr(head),
+		Base:  github.Ptr(base),
+		Body:  github.Ptr(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pull request of %s into %s: %w", head, base, err)
+	}
+
+	errs := make([]error, 0)
+
+	if len(opts.Reviewers) > 0 {
+		users, teams := splitReviewers(opts.Reviewers)
+		_, _, err := c.client.PullRequests.RequestReviewers(ctx, c.owner, c.repo, pr.GetNumber(), github.ReviewersRequest{
+			Reviewers:     users,
+			TeamReviewers: teams,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to request reviewers: %w", err))
+		}
+	}
+
+	if len(opts.Labels) > 0 {
+		if _, _, err := c.client.Issues.AddLabelsToIssue(ctx, c.owner, c.repo, pr.GetNumber(), opts.Labels); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add labels: %w", err))
+		}
+	}
+
+	if opts.AutoMerge {
+		if err := c.EnableAutoMerge(ctx, pr.GetNodeID()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return pr, errors.Join(errs...)
+}
+
+// splitReviewers splits the reviewers into users and teams, a team is given as org/team
+func splitReviewers(reviewers []string) (users, teams []string) {
+
+	for _, reviewer := range reviewers {
+		if _, team, ok := strings.Cut(reviewer, "/"); ok {
+			teams = append(teams, team)
+		} else {
+			users = append(users, reviewer)
+		}
+	}
+
+	return users, teams
+}
+
+// EnableAutoMerge enables the auto-merge of a pull request, only available through the GraphQL API
+func (c *GithubClient) EnableAutoMerge(ctx context.Context, pullRequestNodeID string) error {
+
+	query := map[string]any{
+		"query":     `mutation($id: ID!) { enablePullRequestAutoMerge(input: {pullRequestId: $id}) { clientMutationId } }`,
+		"variables": map[string]any{"id": pullRequestNodeID},
+	}
+
+	req, err := c.client.NewRequest("POST", "graphql", query)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := c.client.Do(ctx, req, &result); err != nil {
+		return fmt.Errorf("failed to enable auto-merge: %w", err)
+	}
+
+	// GraphQL errors are returned with a successful status
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("failed to enable auto-merge: %s", result.Errors[0].Message)
+	}
+
+	return nil
+}
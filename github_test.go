@@ -20,7 +20,7 @@ func TestListCommitsSince(t *testing.T) {
 	// 17 Apr 2025 , 5PM , CET = GMT + 2
 	since := time.Date(2025, 4, 17, 17, 0, 0, 0, time.FixedZone("CET", 2*3600))
 
-	commits, err := client.ListCommitsSince(context.Background(), since, branch)
+	commits, err := client.ListCommitsSince(context.Background(), sinceWindow(since), branch)
 	if err != nil {
 		t.Fatalf("Failed to list commits: %v", err)
 	}
@@ -43,7 +43,7 @@ func TestMasterHistory(t *testing.T) {
 
 	since := time.Now().AddDate(0, -1, 0)
 
-	commits, err := client.ListCommitsSince(context.Background(), since, branch)
+	commits, err := client.ListCommitsSince(context.Background(), sinceWindow(since), branch)
 	if err != nil {
 		t.Fatalf("Failed to list commits: %v", err)
 	}
@@ -52,7 +52,7 @@ func TestMasterHistory(t *testing.T) {
 
 	for _, commit := range masterCommits {
 		fmt.Printf("Commit: %s\n", commit.SHA)
-		fmt.Printf("Parent: %s\n", commit.Parent)
+		fmt.Printf("Parents: %v\n", commit.Parents)
 		fmt.Printf("Date: %v\n", commit.Date)
 		fmt.Printf("--------------------------------\n")
 	}
@@ -61,14 +61,24 @@ func TestMasterHistory(t *testing.T) {
 func TestListGitOpsBranches(t *testing.T) {
 	owner := "trivago"
 	repo := "hotel-search-web"
+	client, err := NewGithubClient(owner, repo)
+	if err != nil {
+		t.Fatalf("Failed to create github client: %v", err)
+	}
+
 	ignore := []string{"gitops/sink", "gitops/infra", "gitops/stage"}
-	branches, err := listGitOpsBranches(owner, repo, ignore)
+	selector, err := NewBranchSelector("gitops/", nil, nil, ignore, ProtectionAll)
+	if err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
+
+	branches, _, err := listGitOpsBranches(client, selector)
 	if err != nil {
 		t.Fatalf("Failed to list gitops branches: %v", err)
 	}
 
 	for _, branch := range branches {
-		fmt.Printf("GitOps Branch: %s\n", branch)
+		fmt.Printf("GitOps Branch: %s (head %s)\n", branch.Name, branch.Head)
 	}
 
 }
@@ -82,33 +92,40 @@ func TestGenerateCommitGraph(t *testing.T) {
 		t.Fatalf("Failed to create github client: %v", err)
 	}
 	ignore := []string{"gitops/sink", "gitops/infra", "gitops/stage", "gitops/seo-indexation"}
+	selector, err := NewBranchSelector("gitops/", nil, nil, ignore, ProtectionAll)
+	if err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
 
 	// List all gitops branches
-	branches, err := listGitOpsBranches(owner, repo, ignore)
+	branches, _, err := listGitOpsBranches(client, selector)
 	if err != nil {
 		t.Fatalf("Failed to list gitops branches: %v", err)
 	}
 
 	// Get all commits since 1 month ago on master
 	since := time.Now().AddDate(0, -1, 0)
-	commits, err := client.ListCommitsSince(context.Background(), since, "master")
+	commits, err := client.ListCommitsSince(context.Background(), sinceWindow(since), "master")
 	if err != nil {
 		t.Fatalf("Failed to list commits: %v", err)
 	}
 
 	masterCommits := processHeadCommits(commits)
 
+	matcher, err := NewReferenceMatcher(nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+
 	path := "manifests/api/prod"
-	commitGraph, commitsHistory, err := generateCommitGraph(owner, repo, branches, masterCommits, path)
+	commitGraph, commitsHistory, err := generateCommitGraph(client, sinceWindow(since), branchNames(branches), masterCommits, []string{path}, matcher)
 	if err != nil {
 		t.Fatalf("Failed to generate commit graph: %v", err)
 	}
 
-	_ = commitsHistory
-
 	// for _, commit := range commitGraph {
 	// 	fmt.Printf("Commit: %s\n", commit.SHA)
-	// 	fmt.Printf("Parent: %s\n", commit.Parent)
+	// 	fmt.Printf("Parents: %v\n", commit.Parents)
 	// 	fmt.Printf("Date: %v\n", commit.Date)
 	// 	fmt.Printf("GitOps Commits: %v\n", commit.GitOpsCommits)
 	// 	fmt.Printf("--------------------------------\n")
@@ -117,13 +134,13 @@ func TestGenerateCommitGraph(t *testing.T) {
 	t.Logf("Finding rollback commits")
 	candidateCommit := "ae0b50669b483e0c91227ea639c025382ba3c24c"
 
-	rollbackCommits, err := findRollbackCommits(commitGraph, branches, candidateCommit)
+	rollbackCommits, err := findRollbackCommits(commitGraph, commitsHistory, branchNames(branches), candidateCommit, WalkFirstParent)
 
 	if err != nil {
 		t.Fatalf("Failed to find rollback commits: %v", err)
 	}
 
-	for branch, commit := range rollbackCommits {
+	for branch, commit := range rollbackCommits.Anchors {
 		fmt.Printf("Branch: %s\n", branch)
 		fmt.Printf("Rollback Commit: %s\n", commit.GitOpsCommit)
 		fmt.Printf("Head Commit: %s\n", commit.HeadCommit)
@@ -131,7 +148,7 @@ func TestGenerateCommitGraph(t *testing.T) {
 	}
 
 	t.Logf("Finding commits after rollback")
-	commitsAfterRollback, err := findCommitsAfterRollback(rollbackCommits, commitsHistory)
+	commitsAfterRollback, err := findCommitsAfterRollback(rollbackCommits.Anchors, commitsHistory)
 	if err != nil {
 		t.Fatalf("Failed to find commits after rollback: %v", err)
 	}
@@ -140,7 +157,7 @@ func TestGenerateCommitGraph(t *testing.T) {
 	for branch, commits := range commitsAfterRollback {
 		fmt.Printf("Branch: %s\n", branch)
 		for i, commit := range commits {
-			fmt.Printf("Commit %d: %s\n", i, commit)
+			fmt.Printf("Commit %d: %s\n", i, commit.SHA)
 		}
 		fmt.Printf("--------------------------------\n")
 	}
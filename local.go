@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/google/go-github/v71/github"
+)
+
+// LocalClient reads commits and branches from an existing clone on disk
+type LocalClient struct {
+	repo   *git.Repository
+	dir    string
+	remote string
+}
+
+func NewLocalClient(dir string) (*LocalClient, error) {
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", dir, err)
+	}
+
+	return &LocalClient{
+		repo:   repo,
+		dir:    dir,
+		remote: "origin",
+	}, nil
+}
+
+// resolveBranch resolves a branch name to its head, preferring a local branch over the remote-tracking one
+func (c *LocalClient) resolveBranch(branch string) (plumbing.Hash, error) {
+
+	refNames := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(branch),
+		plumbing.NewRemoteReferenceName(c.remote, branch),
+	}
+
+	for _, refName := range refNames {
+		ref, err := c.repo.Reference(refName, true)
+		if err == nil {
+			return ref.Hash(), nil
+		}
+		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("branch %s not found in %s", branch, c.dir)
+}
+
+func (c *LocalClient) listCommits(window Window, branch string, pathFilter func(string) bool) ([]*github.RepositoryCommit, error) {
+
+	head, err := c.resolveBranch(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	logIter, err := c.repo.Log(&git.LogOptions{
+		From:  head,
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// LogOptions.Since and PathFilter would skip the older commits but still walk and diff the whole history,
+	// the path filter is applied on top of a walk that stops at the window instead
+	var iter object.CommitIter = &windowCommitIter{CommitIter: logIter, since: window.Since}
+	if pathFilter != nil {
+		iter = object.NewCommitPathIterFromIter(pathFilter, iter, false)
+	}
+	defer iter.Close()
+
+	allCommits := make([]*github.RepositoryCommit, 0)
+	err = iter.ForEach(func(commit *object.Commit) error {
+		allCommits = append(allCommits, toRepositoryCommit(commit))
+		if window.full(len(allCommits)) {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allCommits, nil
+}
+
+// windowCommitIter ends a walk in committer time order at the first commit before the start of the window
+type windowCommitIter struct {
+	object.CommitIter
+	since *time.Time
+}
+
+func (it *windowCommitIter) Next() (*object.Commit, error) {
+
+	commit, err := it.CommitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	if it.since != nil && commit.Committer.When.Before(*it.since) {
+		return nil, io.EOF
+	}
+
+	return commit, nil
+}
+
+func (it *windowCommitIter) ForEach(cb func(*object.Commit) error) error {
+
+	for {
+		commit, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := cb(commit); errors.Is(err, storer.ErrStop) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// ListCommitsSince lists all commits in the window
+func (c *LocalClient) ListCommitsSince(ctx context.Context, window Window, branch string) ([]*github.RepositoryCommit, error) {
+	return c.listCommits(window, branch, nil)
+}
+
+// ListCommitsSinceOnPath lists all commits in the window on a specific path
+func (c *LocalClient) ListCommitsSinceOnPath(ctx context.Context, window Window, branch, path string) ([]*github.RepositoryCommit, error) {
+
+	path = strings.Trim(path, "/")
+	filter := func(file string) bool {
+		return pathContains(path, file)
+	}
+
+	return c.listCommits(window, branch, filter)
+}
+
+// ListCommitFiles lists the files a commit changed compared to its first parent
+func (c *LocalClient) ListCommitFiles(ctx context.Context, sha string) ([]string, error) {
+
+	commit, err := c.repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", sha, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	// A root commit is compared to the empty tree
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.From.Name != "" {
+			files = append(files, change.From.Name)
+		}
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			files = append(files, change.To.Name)
+		}
+	}
+
+	return files, nil
+}
+
+// ListPaths lists the files and directories of the tree at the head of a branch
+func (c *LocalClient) ListPaths(ctx context.Context, branch string) ([]string, error) {
+
+	head, err := c.resolveBranch(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := c.repo.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	paths := make([]string, 0)
+	for {
+		name, _, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, name)
+	}
+
+	return paths, nil
+}
+
+// ListBranches lists all local and remote-tracking branches.
+// Branch protection is not known locally, so protected is ignored and left unset on the branches.
+func (c *LocalClient) ListBranches(ctx context.Context, filter func(string) bool, protected *bool) ([]*github.Branch, error) {
+
+	refs, err := c.repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	remotePrefix := c.remote + "/"
+	seen := make(map[string]bool)
+	gitopsBranches := make([]*github.Branch, 0)
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		var name string
+		switch {
+		case ref.Name().IsBranch():
+			name = ref.Name().Short()
+		case ref.Name().IsRemote() && strings.HasPrefix(ref.Name().Short(), remotePrefix):
+			name = strings.TrimPrefix(ref.Name().Short(), remotePrefix)
+		default:
+			return nil
+		}
+
+		if name == "HEAD" || seen[name] || !filter(name) {
+			return nil
+		}
+		seen[name] = true
+
+		gitopsBranches = append(gitopsBranches, &github.Branch{
+			Name:   github.Ptr(name),
+			Commit: &github.RepositoryCommit{SHA: github.Ptr(ref.Hash().String())},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gitopsBranches, nil
+}
+
+// RequiredChecks returns no checks, branch protection is not known locally
+func (c *LocalClient) RequiredChecks(ctx context.Context, branch string) ([]string, error) {
+	return nil, nil
+}
+
+// ResolveCommit resolves a commit SHA, short or not, a tag (tags/v1.0.0) or a branch to its full commit SHA
+func (c *LocalClient) ResolveCommit(ctx context.Context, ref string) (string, error) {
+
+	hash, err := c.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+
+	return hash.String(), nil
+}
+
+// PullRequestMergeCommit fails, pull requests are only known to GitHub
+func (c *LocalClient) PullRequestMergeCommit(ctx context.Context, number int) (string, error) {
+	return "", fmt.Errorf("pull request #%d can't be resolved with the local source, use -source=github", number)
+}
+
+// ReleaseCommit fails, releases are only known to GitHub
+func (c *LocalClient) ReleaseCommit(ctx context.Context, release string) (string, error) {
+	return "", fmt.Errorf("release %s can't be resolved with the local source, use -source=github or -to-tag", release)
+}
+
+// toRepositoryCommit converts a go-git commit into the shape returned by the GitHub commits API
+func toRepositoryCommit(commit *object.Commit) *github.RepositoryCommit {
+
+	parents := make([]*github.Commit, len(commit.ParentHashes))
+	for i, parent := range commit.ParentHashes {
+		parents[i] = &github.Commit{SHA: github.Ptr(parent.String())}
+	}
+
+	return &github.RepositoryCommit{
+		SHA: github.Ptr(commit.Hash.String()),
+		Commit: &github.Commit{
+			Message: github.Ptr(commit.Message),
+			Author: &github.CommitAuthor{
+				Name:  github.Ptr(commit.Author.Name),
+				Email: github.Ptr(commit.Author.Email),
+				Date:  &github.Timestamp{Time: commit.Author.When},
+			},
+			Committer: &github.CommitAuthor{
+				Name:  github.Ptr(commit.Committer.Name),
+				Email: github.Ptr(commit.Committer.Email),
+				Date:  &github.Timestamp{Time: commit.Committer.When},
+			},
+		},
+		Parents: parents,
+	}
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// fixtureRepo is a throwaway repository used to run the analysis offline
+type fixtureRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+	now  time.Time
+	base plumbing.Hash
+	// mergeFrom is the second parent of the next commit, if any
+	mergeFrom plumbing.Hash
+	// authoredAt is the author date of the next commit if set, like the one of a cherry-picked commit
+	authoredAt time.Time
+}
+
+func newFixtureRepo(t *testing.T) *fixtureRepo {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+
+	f := &fixtureRepo{
+		t:    t,
+		dir:  dir,
+		repo: repo,
+		now:  time.Now().AddDate(0, -2, 0).Truncate(time.Second),
+	}
+
+	// The initial commit is kept outside of the lookback window, like the root of a long-lived repository
+	f.base = plumbing.NewHash(f.commit("master", map[string]string{"README.md": "fixture"}, "Initial commit"))
+	f.now = time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	return f
+}
+
+// commit writes files on a branch and commits them, creating the branch from the initial commit if needed
+func (f *fixtureRepo) commit(branch string, files map[string]string, message string) string {
+	f.t.Helper()
+
+	wt, err := f.repo.Worktree()
+	if err != nil {
+		f.t.Fatalf("Failed to open worktree: %v", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(branch)
+	if !f.base.IsZero() {
+		opts := &git.CheckoutOptions{Branch: refName, Force: true}
+		if _, err := f.repo.Reference(refName, true); err != nil {
+			opts.Create = true
+			opts.Hash = f.base
+		}
+		if err := wt.Checkout(opts); err != nil {
+			f.t.Fatalf("Failed to checkout %s: %v", branch, err)
+		}
+	}
+
+	for name, content := range files {
+		fullPath := filepath.Join(f.dir, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			f.t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+			f.t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			f.t.Fatalf("Failed to add %s: %v", name, err)
+		}
+	}
+
+	f.now = f.now.Add(time.Minute)
+	signature := &object.Signature{Name: "Fixture", Email: "fixture@example.com", When: f.now}
+	commitOpts := &git.CommitOptions{Author: signature, Committer: signature}
+	if !f.authoredAt.IsZero() {
+		commitOpts.Author = &object.Signature{Name: signature.Name, Email: signature.Email, When: f.authoredAt}
+		f.authoredAt = time.Time{}
+	}
+	if !f.mergeFrom.IsZero() {
+		head, err := f.repo.Head()
+		if err != nil {
+			f.t.Fatalf("Failed to resolve head of %s: %v", branch, err)
+		}
+		commitOpts.Parents = []plumbing.Hash{head.Hash(), f.mergeFrom}
+	}
+	hash, err := wt.Commit(message, commitOpts)
+	if err != nil {
+		f.t.Fatalf("Failed to commit on %s: %v", branch, err)
+	}
+
+	return hash.String()
+}
+
+// merge commits files on a branch as the merge of another branch
+func (f *fixtureRepo) merge(branch, from string, files map[string]string, message string) string {
+	f.t.Helper()
+
+	ref, err := f.repo.Reference(plumbing.NewBranchReferenceName(from), true)
+	if err != nil {
+		f.t.Fatalf("Failed to resolve %s: %v", from, err)
+	}
+
+	f.mergeFrom = ref.Hash()
+	defer func() { f.mergeFrom = plumbing.ZeroHash }()
+
+	return f.commit(branch, files, message)
+}
+
+// deploy commits a manifest change on a gitops branch that references a master commit
+func (f *fixtureRepo) deploy(branch, path, masterSHA string) string {
+	f.t.Helper()
+
+	return f.commit(branch,
+		map[string]string{path + "/deployment.yaml": "image: " + masterSHA},
+		fmt.Sprintf("Deploy trivago/hotel-search-web@%s", masterSHA),
+	)
+}
+
+func TestLocalClientAnalysis(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	f.deploy("gitops/api", path, m1)
+	f.deploy("gitops/worker", path, m1)
+
+	m2 := f.commit("master", map[string]string{"app.txt": "v2"}, "Release v2")
+	api2 := f.deploy("gitops/api", path, m2)
+	f.commit("gitops/api", map[string]string{"other/file.txt": "noise"}, "Unrelated change")
+
+	m3 := f.commit("master", map[string]string{"app.txt": "v3"}, "Release v3")
+	api3 := f.deploy("gitops/api", path, m3)
+	worker3 := f.deploy("gitops/worker", path, m3)
+	f.deploy("gitops/api-canary", path, m3)
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	selector, err := NewBranchSelector("gitops/", nil, []string{"gitops/*-canary"}, []string{"gitops/ignored"}, ProtectionAll)
+	if err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
+
+	gitopsBranches, excluded, err := listGitOpsBranches(client, selector)
+	if err != nil {
+		t.Fatalf("Failed to list gitops branches: %v", err)
+	}
+	branches := branchNames(gitopsBranches)
+	slices.Sort(branches)
+	if !slices.Equal(branches, []string{"gitops/api", "gitops/worker"}) {
+		t.Fatalf("Unexpected branches: %v", branches)
+	}
+
+	candidates := candidateExclusions(excluded)
+	if len(candidates) != 1 || candidates[0].Branch != "gitops/api-canary" || candidates[0].Reason != "matched by the exclude pattern gitops/*-canary" {
+		t.Fatalf("Unexpected excluded branches: %v", candidates)
+	}
+
+	commits, err := client.ListCommitsSince(context.Background(), sinceWindow(time.Now().AddDate(0, -1, 0)), "master")
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+	if len(commits) != 3 || commits[0].GetSHA() != m3 {
+		t.Fatalf("Unexpected master history: got %d commits, head %s", len(commits), commits[0].GetSHA())
+	}
+
+	lastCommits, err := client.ListCommitsSince(context.Background(), Window{Commits: 2}, "master")
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+	if len(lastCommits) != 2 || lastCommits[1].GetSHA() != m2 {
+		t.Fatalf("Expected the last 2 master commits, got %d commits", len(lastCommits))
+	}
+
+	matcher, err := NewReferenceMatcher(nil, []string{"trivago/"})
+	if err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+
+	commitGraph, commitsHistory, err := generateCommitGraph(client, sinceWindow(time.Now().AddDate(0, -1, 0)), branches, processHeadCommits(commits), []string{path}, matcher)
+	if err != nil {
+		t.Fatalf("Failed to generate commit graph: %v", err)
+	}
+
+	if got := len(commitsHistory["gitops/api"]); got != 3 {
+		t.Fatalf("Expected 3 commits on path for gitops/api, got %d", got)
+	}
+
+	statuses := findDeployedCommits(gitopsBranches, commitGraph, commitsHistory, matcher)
+	for _, status := range statuses {
+		if status.DeployedCommit != m3 {
+			t.Errorf("Expected %s to have %s deployed, got %s", status.Branch, m3, status.DeployedCommit)
+		}
+	}
+
+	rollbackCommits, err := findRollbackCommits(commitGraph, commitsHistory, branches, m2, WalkFirstParent)
+	if err != nil {
+		t.Fatalf("Failed to find rollback commits: %v", err)
+	}
+
+	if rollbackCommits.Anchors["gitops/api"].GitOpsCommit != api2 {
+		t.Errorf("Expected gitops/api to roll back to %s, got %s", api2, rollbackCommits.Anchors["gitops/api"].GitOpsCommit)
+	}
+	if rollbackCommits.Anchors["gitops/worker"].HeadCommit != m1 {
+		t.Errorf("Expected gitops/worker to roll back to the deploy of %s, got %s", m1, rollbackCommits.Anchors["gitops/worker"].HeadCommit)
+	}
+
+	commitsAfterRollback, err := findCommitsAfterRollback(rollbackCommits.Anchors, commitsHistory)
+	if err != nil {
+		t.Fatalf("Failed to find commits after rollback: %v", err)
+	}
+
+	if got := commitSHAs(commitsAfterRollback["gitops/api"]); !slices.Equal(got, []string{api3}) {
+		t.Errorf("Unexpected commits to revert on gitops/api: %v", got)
+	}
+	if got := commitSHAs(commitsAfterRollback["gitops/worker"]); !slices.Equal(got, []string{worker3}) {
+		t.Errorf("Unexpected commits to revert on gitops/worker: %v", got)
+	}
+}
+
+func TestLocalStatusAfterRollback(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	worker1 := f.deploy("gitops/worker", path, m1)
+	for _, branch := range []string{"gitops/api", "gitops/web"} {
+		f.deploy(branch, path, m1)
+	}
+
+	m2 := f.commit("master", map[string]string{"app.txt": "v2"}, "Release v2")
+	api2 := f.deploy("gitops/api", path, m2)
+	worker2 := f.deploy("gitops/worker", path, m2)
+	web2 := f.deploy("gitops/web", path, m2)
+
+	m3 := f.commit("master", map[string]string{"app.txt": "v3"}, "Release v3")
+	api3 := f.deploy("gitops/api", path, m3)
+
+	// A revert made by hand keeps the subject of the deploy it reverts
+	f.commit("gitops/web", map[string]string{path + "/deployment.yaml": "image: " + m1},
+		fmt.Sprintf("Revert \"Deploy trivago/hotel-search-web@%s\"\n\nThis reverts commit %s.", m2, web2))
+
+	repoDir := cloneFixture(t, f)
+	if err := revertFromCommitCLI(repoDir, "gitops/api", []string{api3, api2}, "Rollback-Id: test", true); err != nil {
+		t.Fatalf("Failed to revert gitops/api: %v", err)
+	}
+	anchor := RollbackCommit{GitOpsCommit: worker1, HeadCommit: m1}
+	superseded := []GitOpsCommit{{SHA: worker2, Message: "Deploy trivago/hotel-search-web@" + m2, Paths: []string{path}}}
+	if err := restorePathsCLI(repoDir, "gitops/worker", anchor, []string{path}, superseded, "Rollback-Id: test", true); err != nil {
+		t.Fatalf("Failed to restore gitops/worker: %v", err)
+	}
+
+	client, err := NewLocalClient(repoDir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+	selector, err := NewBranchSelector("gitops/", nil, nil, nil, ProtectionAll)
+	if err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
+	gitopsBranches, _, err := listGitOpsBranches(client, selector)
+	if err != nil {
+		t.Fatalf("Failed to list gitops branches: %v", err)
+	}
+
+	window := sinceWindow(time.Now().AddDate(0, -1, 0))
+	commits, err := client.ListCommitsSince(context.Background(), window, "master")
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+	matcher, err := NewReferenceMatcher(nil, []string{"trivago/"})
+	if err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+	commitGraph, commitsHistory, err := generateCommitGraph(client, window, branchNames(gitopsBranches), processHeadCommits(commits), []string{path}, matcher)
+	if err != nil {
+		t.Fatalf("Failed to generate commit graph: %v", err)
+	}
+
+	statuses := findDeployedCommits(gitopsBranches, commitGraph, commitsHistory, matcher)
+	if len(statuses) != 3 {
+		t.Fatalf("Expected the status of 3 branches, got %+v", statuses)
+	}
+	for _, status := range statuses {
+		if status.DeployedCommit != m1 {
+			t.Errorf("Expected %s to have %s deployed after the rollback, got %s", status.Branch, m1, status.DeployedCommit)
+		}
+	}
+}
+
+func TestLocalClientMultiplePaths(t *testing.T) {
+
+	f := newFixtureRepo(t)
+
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	api1 := f.deploy("gitops/api", "manifests/api/prod", m1)
+	worker1 := f.deploy("gitops/api", "manifests/api-worker/prod", m1)
+	both := f.commit("gitops/api", map[string]string{
+		"manifests/api/prod/config.yaml":        "replicas: 3",
+		"manifests/api-worker/prod/config.yaml": "replicas: 3",
+	}, "Scale api and worker")
+	f.deploy("gitops/api", "manifests/api/stage", m1)
+
+	// A cherry-picked commit keeps the author date of the original, older than the commits before it
+	f.authoredAt = f.now.Add(-time.Hour)
+	picked := f.commit("gitops/api", map[string]string{"manifests/api-worker/prod/config.yaml": "replicas: 4"}, "Scale worker")
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	paths, err := expandPaths(client, "gitops/api", []string{"manifests/*/prod"})
+	if err != nil {
+		t.Fatalf("Failed to expand paths: %v", err)
+	}
+	if !slices.Equal(paths, []string{"manifests/api-worker/prod", "manifests/api/prod"}) {
+		t.Fatalf("Unexpected expanded paths: %v", paths)
+	}
+
+	commits, err := listCommitsOnPaths(client, sinceWindow(time.Now().AddDate(0, -1, 0)), "gitops/api", paths)
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+
+	if got := commitSHAs(commits); !slices.Equal(got, []string{picked, both, worker1, api1}) {
+		t.Fatalf("Unexpected merged history: %v", got)
+	}
+	if !slices.Equal(commits[1].Paths, paths) {
+		t.Errorf("Expected the commit touching both paths to record them, got %v", commits[1].Paths)
+	}
+	if !slices.Equal(commits[2].Paths, []string{"manifests/api-worker/prod"}) {
+		t.Errorf("Unexpected paths of the worker deploy: %v", commits[2].Paths)
+	}
+}
+
+// sliceCommitIter walks a list of commits in order
+type sliceCommitIter struct {
+	object.CommitIter
+	commits []*object.Commit
+}
+
+func (it *sliceCommitIter) Next() (*object.Commit, error) {
+
+	if len(it.commits) == 0 {
+		return nil, io.EOF
+	}
+
+	commit := it.commits[0]
+	it.commits = it.commits[1:]
+	return commit, nil
+}
+
+func TestWindowCommitIterStops(t *testing.T) {
+
+	now := time.Now()
+	commit := func(age time.Duration) *object.Commit {
+		return &object.Commit{Hash: plumbing.ComputeHash(plumbing.CommitObject, []byte(age.String())), Committer: object.Signature{When: now.Add(-age)}}
+	}
+
+	// The walk ends at the first commit before the window, even if newer commits come after it
+	since := now.Add(-24 * time.Hour)
+	commits := &sliceCommitIter{commits: []*object.Commit{commit(time.Hour), commit(48 * time.Hour), commit(2 * time.Hour)}}
+	iter := &windowCommitIter{CommitIter: commits, since: &since}
+
+	walked := 0
+	if err := iter.ForEach(func(*object.Commit) error { walked++; return nil }); err != nil {
+		t.Fatalf("Failed to walk the commits: %v", err)
+	}
+	if walked != 1 {
+		t.Errorf("Expected the walk to stop at the commit before the window, walked %d commits", walked)
+	}
+}
+
+func TestLocalExtendHistory(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+
+	f.now = time.Now().AddDate(0, 0, -10).Truncate(time.Second)
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	f.deploy("gitops/api", path, m1)
+	worker1 := f.deploy("gitops/worker", path, m1)
+
+	f.now = time.Now().AddDate(0, 0, -5).Truncate(time.Second)
+	m2 := f.commit("master", map[string]string{"app.txt": "v2"}, "Release v2")
+	api2 := f.deploy("gitops/api", path, m2)
+
+	f.now = time.Now().Add(-time.Hour).Truncate(time.Second)
+	m3 := f.commit("master", map[string]string{"app.txt": "v3"}, "Release v3")
+	f.deploy("gitops/api", path, m3)
+	f.deploy("gitops/worker", path, m3)
+	f.commit("gitops/idle", map[string]string{path + "/deployment.yaml": "manual"}, "Manual change")
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.Paths = []string{path}
+	if opts.Window, err = parseWindow("48h", time.Now()); err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	if opts.MaxWindow, err = parseWindow("240h", time.Now()); err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+
+	a := &analysis{opts: opts, source: client}
+	if a.matcher, err = NewReferenceMatcher(nil, nil); err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+	if a.selector, err = NewBranchSelector("gitops/", nil, nil, nil, ProtectionAll); err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
+
+	if err := a.buildGraph(); err != nil {
+		t.Fatalf("Failed to build graph: %v", err)
+	}
+
+	rollbackCommits, err := a.extendHistory(m2)
+	if err != nil {
+		t.Fatalf("Failed to extend history: %v", err)
+	}
+
+	if rollbackCommits.Anchors["gitops/api"].GitOpsCommit != api2 {
+		t.Errorf("Expected gitops/api to roll back to %s, got %s", api2, rollbackCommits.Anchors["gitops/api"].GitOpsCommit)
+	}
+	if rollbackCommits.Anchors["gitops/worker"].GitOpsCommit != worker1 {
+		t.Errorf("Expected gitops/worker to roll back to %s after widening, got %s", worker1, rollbackCommits.Anchors["gitops/worker"].GitOpsCommit)
+	}
+	if !slices.Equal(rollbackCommits.NeverDeployed, []string{"gitops/idle"}) {
+		t.Errorf("Expected gitops/idle to be reported as never deployed, got %v", rollbackCommits.NeverDeployed)
+	}
+	if !a.window.Since.Equal(*opts.MaxWindow.Since) {
+		t.Errorf("Expected the window to be widened to the maximum, got %s", a.window)
+	}
+
+	opts.MaxWindow = opts.Window
+	if err := a.buildGraph(); err != nil {
+		t.Fatalf("Failed to build graph: %v", err)
+	}
+	if _, err := a.extendHistory(m2); err == nil {
+		t.Errorf("Expected an error when the target is outside the maximum lookback window")
+	}
+}
+
+func TestLocalMergeCommitAncestry(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	api1 := f.deploy("gitops/api", path, m1)
+
+	// A hotfix released from a release branch, deployed before it is merged back into master
+	r1 := f.commit("release/1", map[string]string{"hotfix.txt": "fix"}, "Hotfix")
+	apiHotfix := f.deploy("gitops/api", path, r1)
+
+	merged := f.merge("master", "release/1", map[string]string{"hotfix.txt": "fix"}, "Merge release/1")
+	m2 := f.commit("master", map[string]string{"app.txt": "v2"}, "Release v2")
+	f.deploy("gitops/api", path, m2)
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	window := sinceWindow(time.Now().AddDate(0, -1, 0))
+	commits, err := client.ListCommitsSince(context.Background(), window, "master")
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+
+	masterCommits := processHeadCommits(commits)
+	if parents := masterCommits[merged].Parents; !slices.Equal(parents, []string{m1, r1}) {
+		t.Fatalf("Expected the merge commit to have both parents, got %v", parents)
+	}
+
+	matcher, err := NewReferenceMatcher(nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+
+	commitGraph, commitsHistory, err := generateCommitGraph(client, window, []string{"gitops/api"}, masterCommits, []string{path}, matcher)
+	if err != nil {
+		t.Fatalf("Failed to generate commit graph: %v", err)
+	}
+
+	if got := ancestors(commitGraph, merged, WalkFirstParent); !slices.Equal(got, []string{merged, m1}) {
+		t.Errorf("Unexpected first-parent ancestors: %v", got)
+	}
+	if got := ancestors(commitGraph, merged, WalkFull); !slices.Equal(got, []string{merged, m1, r1}) {
+		t.Errorf("Unexpected full ancestors: %v", got)
+	}
+
+	tests := []struct {
+		walk string
+		want RollbackCommit
+	}{
+		{walk: WalkFirstParent, want: RollbackCommit{GitOpsCommit: api1, HeadCommit: m1}},
+		{walk: WalkFull, want: RollbackCommit{GitOpsCommit: apiHotfix, HeadCommit: r1}},
+	}
+
+	for _, tt := range tests {
+		rollbackCommits, err := findRollbackCommits(commitGraph, commitsHistory, []string{"gitops/api"}, merged, tt.walk)
+		if err != nil {
+			t.Fatalf("Failed to find rollback commits: %v", err)
+		}
+		if got := rollbackCommits.Anchors["gitops/api"]; got != tt.want {
+			t.Errorf("Expected the %s walk to anchor on %+v, got %+v", tt.walk, tt.want, got)
+		}
+	}
+}
+
+func TestProcessHeadCommitsRootCommit(t *testing.T) {
+
+	f := newFixtureRepo(t)
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	commits, err := client.ListCommitsSince(context.Background(), Window{Commits: 1}, "master")
+	if err != nil {
+		t.Fatalf("Failed to list commits: %v", err)
+	}
+
+	headCommits := processHeadCommits(commits)
+	if root := headCommits[f.base.String()]; root == nil || len(root.Parents) != 0 {
+		t.Errorf("Expected the root commit without parents, got %+v", root)
+	}
+}
+
+func TestLocalResolveCommit(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+
+	signature := &object.Signature{Name: "Fixture", Email: "fixture@example.com", When: f.now}
+	if _, err := f.repo.CreateTag("v1.0.0", plumbing.NewHash(m1), &git.CreateTagOptions{Tagger: signature, Message: "v1.0.0"}); err != nil {
+		t.Fatalf("Failed to create tag: %v", err)
+	}
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	for _, ref := range []string{"tags/v1.0.0", m1[:8], m1} {
+		sha, err := client.ResolveCommit(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", ref, err)
+		}
+		if sha != m1 {
+			t.Errorf("Expected %s to resolve to %s, got %s", ref, m1, sha)
+		}
+	}
+
+	if _, err := client.ResolveCommit(context.Background(), "tags/v9.9.9"); err == nil {
+		t.Errorf("Expected an error for a missing tag")
+	}
+
+	target := &analysis{opts: &Options{Target: strings.Repeat("0", 40)}, source: client}
+	if _, err := target.resolveTarget(); err == nil {
+		t.Errorf("Expected an error for a full SHA unknown to the repository")
+	}
+	if _, err := client.PullRequestMergeCommit(context.Background(), 1); err == nil {
+		t.Errorf("Expected an error for a pull request with the local source")
+	}
+}
+
+func TestLocalBadCommitPlan(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+
+	m1 := f.commit("master", map[string]string{"app.txt": "v1"}, "Release v1")
+	api1 := f.deploy("gitops/api", path, m1)
+	bad := f.commit("master", map[string]string{"app.txt": "v2"}, "Release v2")
+	api2 := f.deploy("gitops/api", path, bad)
+	m3 := f.commit("master", map[string]string{"app.txt": "v3"}, "Release v3")
+	api3 := f.deploy("gitops/api", path, m3)
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.Paths = []string{path}
+	opts.BadCommit = bad[:10]
+	opts.Window = sinceWindow(time.Now().AddDate(0, -1, 0))
+	opts.MaxWindow = opts.Window
+	if err := opts.validateTarget(); err != nil {
+		t.Fatalf("Invalid target: %v", err)
+	}
+
+	a := &analysis{opts: opts, source: client}
+	if a.matcher, err = NewReferenceMatcher(nil, nil); err != nil {
+		t.Fatalf("Failed to create reference matcher: %v", err)
+	}
+	if a.selector, err = NewBranchSelector("gitops/", nil, nil, nil, ProtectionAll); err != nil {
+		t.Fatalf("Failed to create branch selector: %v", err)
+	}
+
+	plan, err := a.plan()
+	if err != nil {
+		t.Fatalf("Failed to compute plan: %v", err)
+	}
+
+	if plan.Target.SHA != m1 || !strings.HasPrefix(plan.Target.Selector, "parent of bad commit") {
+		t.Fatalf("Expected the parent %s of the bad commit as target, got %+v", m1, plan.Target)
+	}
+	if len(plan.Branches) != 1 || plan.Branches[0].Anchor.GitOpsCommit != api1 {
+		t.Fatalf("Unexpected branches in plan: %+v", plan.Branches)
+	}
+	if got := commitSHAs(plan.Branches[0].Commits); !slices.Equal(got, []string{api3, api2}) {
+		t.Errorf("Unexpected commits to revert: %v", got)
+	}
+}
+
+func TestLocalListCommitFiles(t *testing.T) {
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	sha := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2", "manifests/worker/prod/deployment.yaml": "v2"}, "Deploy v2")
+
+	client, err := NewLocalClient(f.dir)
+	if err != nil {
+		t.Fatalf("Failed to create local client: %v", err)
+	}
+
+	files, err := client.ListCommitFiles(context.Background(), sha)
+	if err != nil {
+		t.Fatalf("Failed to list commit files: %v", err)
+	}
+	slices.Sort(files)
+
+	want := []string{path + "/deployment.yaml", "manifests/worker/prod/deployment.yaml"}
+	if !slices.Equal(files, want) {
+		t.Errorf("Expected files %v, got %v", want, files)
+	}
+
+	if leftAlone := outsidePaths(files, []string{path}); !slices.Equal(leftAlone, want[1:]) {
+		t.Errorf("Expected %v to be left alone, got %v", want[1:], leftAlone)
+	}
+}
@@ -1,172 +1,87 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"strings"
-	"sync"
 	"time"
 )
 
 func main() {
 
-	start := time.Now()
-
-	desiredCommitHashFlag := flag.String("desiredCommitHash", "", "The Desired Commit Hash to revert gitops branches to its state")
-	ownerFlag := flag.String("owner", "trivago", "The Owner of the GitHub repository")
-	repoFlag := flag.String("repo", "hsw-fork", "The Name of the GitHub repository")
-	pathFlag := flag.String("path", "manifests/api/prod", "The Path within the gitops branches to analyze")
-	ignoreBranchesFlag := flag.String("ignoreBranches", "gitops/sink,gitops/infra,gitops/stage,gitops/seo-indexation,gitops/member-data", "The Comma-separated list of gitops branches to ignore")
-	sinceFlag := flag.Int("since", 1, "The Number of months ago to get the commits")
-	rollbackFlag := flag.Bool("rollback", false, "The Mode to run the program, if true, it will run in rollback mode. Otherwise, it will just print the commits to revert")
-	pushFlag := flag.Bool("push", false, "if true, it will push the changes to the remote repository. Otherwise, it will just commit the changes")
-
-	flag.Usage = func() {
-		fmt.Printf("\nUsage: %s <desiredCommitHash> <owner> <repo> <path> <Comma-separated list of gitops branches to ignore> <since> <rollback> <push>\n", os.Args[0])
-		fmt.Printf("\nEnvironment variables:")
-		fmt.Printf("\n  GITHUB_TOKEN     GitHub personal access token (required)")
-		fmt.Printf("\n")
-		fmt.Printf("\nExample: %s f50d95b53a5d9fdb2a1039b6a86aa180ee1afb3d trivago hotel-search-web manifests/api/prod gitops/skip-branch,gitops/infra 1 true false\n", os.Args[0])
-		flag.PrintDefaults()
-	}
-
-	if os.Getenv("GITHUB_TOKEN") == "" {
-		log.Fatalf("GITHUB_TOKEN environment variable is not set")
-	}
-
-	flag.Parse()
-
-	commitHash := *desiredCommitHashFlag
-	since := time.Now().AddDate(0, -*sinceFlag, 0)
-	owner := *ownerFlag
-	repo := *repoFlag
-	path := *pathFlag
-	ignoreBranches := strings.Split(*ignoreBranchesFlag, ",")
-	rollbackMode := *rollbackFlag
-	pushMode := *pushFlag
-
-	client, err := NewGithubClient(owner, repo)
-	if err != nil {
-		log.Fatalf("Failed to create github client: %v", err)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(ExitUsage)
 	}
 
-	// List all gitops branches
-	branches, err := listGitOpsBranches(owner, repo, ignoreBranches)
-	if err != nil {
-		log.Fatalf("Failed to list gitops branches: %v", err)
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return
 	}
 
-	// Get all commits since <since> months ago on master
-	commits, err := client.ListCommitsSince(context.Background(), since, "master")
-	if err != nil {
-		log.Fatalf("Failed to list commits: %v", err)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+		usage()
+		os.Exit(ExitUsage)
 	}
 
-	masterCommits := processHeadCommits(commits)
-
-	commitGraph, commitsHistory, err := generateCommitGraph(owner, repo, branches, masterCommits, path)
-	if err != nil {
-		log.Fatalf("Failed to generate commit graph: %v", err)
+	if err := cmd.run(cmd.newFlagSet(), os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		var exit *exitError
+		if errors.As(err, &exit) {
+			log.Printf("%s: %v", cmd.name, exit)
+			os.Exit(exit.code)
+		}
+		log.Fatalf("%s failed: %v", cmd.name, err)
 	}
+}
 
-	log.Printf("Commit Graph:")
-	log.Printf("------------------- START COMMIT GRAPH -------------------")
-	for _, commit := range commitGraph {
-		log.Printf("Commit: %s\n", commit.SHA)
-		log.Printf("Parent: %s\n", commit.Parent)
-		log.Printf("Date: %v\n", commit.Date)
-		log.Printf("GitOps Commits: %v\n", commit.GitOpsCommits)
-		log.Printf("--------------------------------\n")
-	}
-	log.Printf("------------------- END COMMIT GRAPH -------------------")
+// exitError ends the command with a specific exit code, other errors exit with 1
+type exitError struct {
+	code int
+	err  error
+}
 
-	rollbackCommits, err := findRollbackCommits(commitGraph, branches, commitHash)
-	if err != nil {
-		log.Fatalf("Failed to find rollback commits: %v", err)
-	}
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
 
-	log.Printf("Rollback Commits:")
-	log.Printf("------------------- START ROLLBACK COMMITS -------------------")
-	for branch, commit := range rollbackCommits {
-		log.Printf("------------ START BRANCH %s-------------\n", branch)
-		log.Printf("Branch: %s\n", branch)
-		log.Printf("GitOps Commit: %s\n", commit.GitOpsCommit)
-		log.Printf("Head Commit: %s\n", commit.HeadCommit)
-		log.Printf("------------ END BRANCH %s-------------\n", branch)
-	}
-	log.Printf("------------------- END ROLLBACK COMMITS -------------------")
+// ExitUsage is the exit code of a wrong command line: an unknown command, flag or option value, or a missing target
+const ExitUsage = 2
 
-	log.Printf("Finding commits after the gitops commit related to the desired commit")
-	commitsAfterRollback, err := findCommitsAfterRollback(rollbackCommits, commitsHistory)
-	if err != nil {
-		log.Fatalf("Failed to find commits after the gitops commit related to the desired commit: %v", err)
-	}
+// usageError ends the command with the exit code of a wrong usage
+func usageError(err error) error {
+	return &exitError{code: ExitUsage, err: err}
+}
 
-	numberOfBranchesToProcess := 0
-	for _, commits := range commitsAfterRollback {
-		if len(commits) > 0 {
-			numberOfBranchesToProcess++
-		}
+// newSource creates the SCM backend used for the analysis
+func newSource(kind, owner, repo, repoDir string) (Source, error) {
+	switch kind {
+	case "github":
+		return NewGithubClient(owner, repo)
+	case "local":
+		return NewLocalClient(repoDir)
+	default:
+		return nil, fmt.Errorf("unknown source %q, expected github or local", kind)
 	}
+}
 
-	log.Printf("Number of branches to process: %d", numberOfBranchesToProcess)
-
-	//  Newest to oldest
-	log.Printf("------------------- START ROLLBACK -------------------")
-
+// cloneRepository clones the repository into a temporary directory
+func cloneRepository(owner, repo string, start time.Time) (string, error) {
 	log.Printf("------------------- START CLONING REPOSITORIES -------------------")
 	repoDir, err := cloneRepositoryCLI(owner, repo)
-	defer os.RemoveAll(repoDir)
 	if err != nil {
-		log.Fatalf("Failed to clone repository: %v", err)
+		os.RemoveAll(repoDir)
+		return "", fmt.Errorf("failed to clone repository: %w", err)
 	}
 	log.Printf("Repository cloned in %v in directory %s", time.Since(start), repoDir)
 	log.Printf("------------------- END CLONING REPOSITORIES -------------------")
 
-	var wg sync.WaitGroup
-	concurrencyLimit := 20
-	if numberOfBranchesToProcess < concurrencyLimit {
-		concurrencyLimit = numberOfBranchesToProcess
-	}
-
-	sem := make(chan struct{}, concurrencyLimit)
-	for branch, commits := range commitsAfterRollback {
-		// Create a worker per branch that has commits to process
-		if len(commits) == 0 {
-			log.Printf("------------ START BRANCH %s-------------\n", branch)
-			log.Printf("No commits to revert on branch %s", branch)
-			log.Printf("------------ END BRANCH %s-------------\n", branch)
-			continue
-		}
-
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(branch string, commits []string) {
-			defer func() { <-sem; wg.Done() }()
-			log.Printf("------------ START BRANCH %s-------------\n", branch)
-			log.Printf("Branch: %s\n", branch)
-			log.Printf("Number of commits to revert: %d\n", len(commits))
-
-			for i, commit := range commits {
-				log.Printf("Commit %d: %s\n", i+1, commit)
-			}
-
-			log.Printf("Reverting %d commits on branch %s", len(commits), branch)
-			if !rollbackMode {
-				log.Printf("Skipping revert of commits on branch %s, rollbackMode is false", branch)
-				log.Printf("------------ END BRANCH %s-------------\n", branch)
-				return
-			}
-			if err := revertFromCommitCLI(repoDir, branch, commits, true, pushMode); err != nil {
-				log.Printf("Failed to revert commits on branch %s: %v", branch, err)
-			}
-			log.Printf("------------ END BRANCH %s-------------\n", branch)
-		}(branch, commits)
-	}
-	wg.Wait()
-	log.Printf("------------------- END ROLLBACK -------------------")
-	log.Printf("Rollback completed in %v", time.Since(start))
+	return repoDir, nil
 }
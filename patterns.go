@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// referencePresets are the commit-message formats our pipelines use to reference a master commit.
+// Every pattern captures the commit in the "sha" group and optionally the source repository in the "repo" group.
+var referencePresets = map[string]string{
+	// trivago/hotel-search-web@<sha>
+	"repo-sha": `(?P<repo>[\w-]+/[\w-]+)@(?P<sha>[0-9a-f]{7,40})\b`,
+	// Source-Commit: <sha>
+	"trailer": `(?mi)^Source-Commit:\s*(?P<sha>[0-9a-f]{7,40})\b`,
+	// https://github.com/trivago/hotel-search-web/commit/<sha>
+	"url": `https://github\.com/(?P<repo>[\w.-]+/[\w.-]+)/commit/(?P<sha>[0-9a-f]{7,40})\b`,
+}
+
+// defaultReferencePatterns is used when no pattern is configured
+var defaultReferencePatterns = []string{"repo-sha"}
+
+// ReferenceMatcher links a gitops commit back to the master commit it deploys
+type ReferenceMatcher struct {
+	patterns []*regexp.Regexp
+	repos    []string
+}
+
+// NewReferenceMatcher compiles the given patterns, each either a preset name or a regexp with a named "sha" group.
+// If repos is not empty, only references whose owner/repo starts with one of them are accepted.
+func NewReferenceMatcher(patterns []string, repos []string) (*ReferenceMatcher, error) {
+
+	if len(patterns) == 0 {
+		patterns = defaultReferencePatterns
+	}
+
+	matcher := &ReferenceMatcher{
+		patterns: make([]*regexp.Regexp, 0, len(patterns)),
+	}
+
+	for _, pattern := range patterns {
+		if preset, ok := referencePresets[pattern]; ok {
+			pattern = preset
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message pattern %q: %w", pattern, err)
+		}
+
+		if re.SubexpIndex("sha") == -1 {
+			return nil, fmt.Errorf("message pattern %q has no named capture group \"sha\"", pattern)
+		}
+
+		matcher.patterns = append(matcher.patterns, re)
+	}
+
+	for _, repo := range repos {
+		repo = strings.ToLower(strings.TrimSpace(repo))
+		if repo != "" {
+			matcher.repos = append(matcher.repos, repo)
+		}
+	}
+
+	return matcher, nil
+}
+
+// Resolve returns the full SHA of the master commit referenced by a commit message, or an empty string.
+// Short SHAs are expanded against the known master commits and ambiguous prefixes are ignored.
+func (m *ReferenceMatcher) Resolve(message string, headCommits map[string]*HeadCommit) string {
+
+	for _, re := range m.patterns {
+		shaIndex := re.SubexpIndex("sha")
+		repoIndex := re.SubexpIndex("repo")
+
+		for _, match := range re.FindAllStringSubmatch(message, -1) {
+			if repoIndex != -1 && !m.allowedRepo(match[repoIndex]) {
+				continue
+			}
+
+			if sha := expandSHA(strings.ToLower(match[shaIndex]), headCommits); sha != "" {
+				return sha
+			}
+		}
+	}
+
+	return ""
+}
+
+// allowedRepo reports whether a referenced owner/repo is an accepted source
+func (m *ReferenceMatcher) allowedRepo(repo string) bool {
+
+	if len(m.repos) == 0 {
+		return true
+	}
+
+	repo = strings.ToLower(repo)
+	return slices.ContainsFunc(m.repos, func(prefix string) bool {
+		return strings.HasPrefix(repo, prefix)
+	})
+}
+
+// expandSHA resolves a full or abbreviated SHA to a known master commit
+func expandSHA(sha string, headCommits map[string]*HeadCommit) string {
+
+	if _, ok := headCommits[sha]; ok {
+		return sha
+	}
+
+	if len(sha) == 40 {
+		return ""
+	}
+
+	found := ""
+	for candidate := range headCommits {
+		if !strings.HasPrefix(candidate, sha) {
+			continue
+		}
+
+		// Ambiguous prefix
+		if found != "" {
+			return ""
+		}
+		found = candidate
+	}
+
+	return found
+}
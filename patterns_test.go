@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReferenceMatcherResolve(t *testing.T) {
+
+	full := "ae0b50669b483e0c91227ea639c025382ba3c24c"
+	other := "ae0b506f00000000000000000000000000000000"
+	headCommits := map[string]*HeadCommit{
+		full:  {SHA: full},
+		other: {SHA: other},
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		repos    []string
+		message  string
+		want     string
+	}{
+		{
+			name:    "default repo-sha reference",
+			message: "Deploy trivago/hotel-search-web@" + full,
+			want:    full,
+		},
+		{
+			name:    "unknown master commit",
+			message: "Deploy trivago/hotel-search-web@0000000000000000000000000000000000000000",
+			want:    "",
+		},
+		{
+			name:     "trailer",
+			patterns: []string{"trailer"},
+			message:  "Update image\n\nSource-Commit: " + full + "\n",
+			want:     full,
+		},
+		{
+			name:     "commit url",
+			patterns: []string{"url"},
+			message:  "Deploy https://github.com/trivago/hotel-search-web/commit/" + full,
+			want:     full,
+		},
+		{
+			name:    "short sha is expanded",
+			message: "Deploy trivago/hotel-search-web@ae0b5066",
+			want:    full,
+		},
+		{
+			name:    "ambiguous short sha is ignored",
+			message: "Deploy trivago/hotel-search-web@ae0b506",
+			want:    "",
+		},
+		{
+			name:    "source repository restriction",
+			repos:   []string{"trivago/hotel-search-web"},
+			message: "Deploy trivago/other-service@" + other + ", trivago/hotel-search-web@" + full,
+			want:    full,
+		},
+		{
+			name:    "source repository prefix rejects other owners",
+			repos:   []string{"trivago/"},
+			message: "Deploy someone/hotel-search-web@" + full,
+			want:    "",
+		},
+		{
+			name:     "custom pattern",
+			patterns: []string{`image: [\w./-]+:(?P<sha>[0-9a-f]{40})`},
+			message:  "image: registry.example.com/api:" + full,
+			want:     full,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewReferenceMatcher(tt.patterns, tt.repos)
+			if err != nil {
+				t.Fatalf("Failed to create reference matcher: %v", err)
+			}
+
+			if got := matcher.Resolve(tt.message, headCommits); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferenceMatcherRequiresSHAGroup(t *testing.T) {
+
+	if _, err := NewReferenceMatcher([]string{`[0-9a-f]{40}`}, nil); err == nil {
+		t.Fatalf("Expected an error for a pattern without a sha group")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PlanVersion is bumped whenever the plan document changes in an incompatible way
+const PlanVersion = 2
+
+// Plan is the rollback plan computed by the analysis, it's the single object the rollback acts on
+type Plan struct {
+	Version     int             `json:"version" yaml:"version"`
+	ID          string          `json:"id" yaml:"id"`
+	CreatedAt   time.Time       `json:"createdAt" yaml:"createdAt"`
+	Owner       string          `json:"owner" yaml:"owner"`
+	Repo        string          `json:"repo" yaml:"repo"`
+	Paths       []string        `json:"paths" yaml:"paths"`
+	Window      Window          `json:"window" yaml:"window"`
+	Target      PlanTarget      `json:"target" yaml:"target"`
+	RollForward string          `json:"rollForward,omitempty" yaml:"rollForward,omitempty"`
+	Selection   PlanSelection   `json:"selection" yaml:"selection"`
+	Strategy    string          `json:"strategy" yaml:"strategy"`
+	Scope       string          `json:"scope" yaml:"scope"`
+	Branches    []BranchPlan    `json:"branches" yaml:"branches"`
+	Skipped     []SkippedBranch `json:"skipped" yaml:"skipped"`
+}
+
+// PlanTarget is the master commit the gitops branches are rolled back to
+type PlanTarget struct {
+	SHA  string    `json:"sha" yaml:"sha"`
+	Date time.Time `json:"date" yaml:"date"`
+	// Selector the target was given with
+	Selector string `json:"selector,omitempty" yaml:"selector,omitempty"`
+	// Walk of the master history the deployed ancestors of the target were found with
+	Walk string `json:"walk,omitempty" yaml:"walk,omitempty"`
+}
+
+// PlanSelection records how the gitops branches were selected and the resolved branch set
+type PlanSelection struct {
+	Include    []string         `json:"include" yaml:"include"`
+	Exclude    []string         `json:"exclude" yaml:"exclude"`
+	Protection string           `json:"protection" yaml:"protection"`
+	Branches   []string         `json:"branches" yaml:"branches"`
+	Excluded   []ExcludedBranch `json:"excluded" yaml:"excluded"`
+}
+
+// BranchPlan describes the rollback of a single gitops branch
+type BranchPlan struct {
+	Branch         string         `json:"branch" yaml:"branch"`
+	Head           string         `json:"head" yaml:"head"`
+	Protected      *bool          `json:"protected,omitempty" yaml:"protected,omitempty"`
+	RequiredChecks []string       `json:"requiredChecks,omitempty" yaml:"requiredChecks,omitempty"`
+	Anchor         RollbackCommit `json:"anchor" yaml:"anchor"`
+	// Commits to revert, newest to oldest
+	Commits []GitOpsCommit `json:"commits" yaml:"commits"`
+}
+
+// SkippedBranch is a gitops branch that is left untouched by the rollback
+type SkippedBranch struct {
+	Branch string `json:"branch" yaml:"branch"`
+	Reason string `json:"reason" yaml:"reason"`
+}
+
+// buildPlan assembles the rollback plan from the results of the analysis
+func buildPlan(owner, repo string, paths []string, window Window, target *HeadCommit, branches []GitOpsBranch, anchors *AnchorResult, commitsAfterRollback map[string][]GitOpsCommit) *Plan {
+
+	createdAt := time.Now().UTC()
+
+	plan := &Plan{
+		Version:   PlanVersion,
+		ID:        fmt.Sprintf("%s-%.7s", createdAt.Format("20060102T150405Z"), target.SHA),
+		CreatedAt: createdAt,
+		Owner:     owner,
+		Repo:      repo,
+		Paths:     paths,
+		Window:    window,
+		Target: PlanTarget{
+			SHA:  target.SHA,
+			Date: target.Date,
+		},
+		Branches: make([]BranchPlan, 0),
+		Skipped:  make([]SkippedBranch, 0),
+	}
+
+	for _, branch := range branches {
+		anchor, ok := anchors.Anchors[branch.Name]
+		if !ok {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: unanchoredReason(anchors, branch.Name, window),
+			})
+			continue
+		}
+
+		commits, ok := commitsAfterRollback[branch.Name]
+		if !ok {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: fmt.Sprintf("anchor commit %s not found in branch history", anchor.GitOpsCommit),
+			})
+			continue
+		}
+
+		if len(commits) == 0 {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: "already at the target state, no commits to revert",
+			})
+			continue
+		}
+
+		plan.Branches = append(plan.Branches, BranchPlan{
+			Branch:         branch.Name,
+			Head:           branch.Head,
+			Protected:      branch.Protected,
+			RequiredChecks: branch.RequiredChecks,
+			Anchor:         anchor,
+			Commits:        commits,
+		})
+	}
+
+	slices.SortFunc(plan.Branches, func(a, b BranchPlan) int { return strings.Compare(a.Branch, b.Branch) })
+	slices.SortFunc(plan.Skipped, func(a, b SkippedBranch) int { return strings.Compare(a.Branch, b.Branch) })
+
+	return plan
+}
+
+// unanchoredReason explains why a branch has no anchor
+func unanchoredReason(anchors *AnchorResult, branch string, window Window) string {
+
+	if slices.Contains(anchors.NeverDeployed, branch) {
+		return fmt.Sprintf("no deploy of any master commit found in the history %s", window)
+	}
+
+	if oldest, ok := anchors.DeployedAfterTarget[branch]; ok {
+		return fmt.Sprintf("oldest deploy found in the history %s is %s of %s, newer than the target", window, oldest.GitOpsCommit, oldest.HeadCommit)
+	}
+
+	if oldest, ok := anchors.DeployedNonAncestors[branch]; ok {
+		return fmt.Sprintf("deployed only non-ancestors of the target in the history %s, the oldest deploy is %s of %s", window, oldest.GitOpsCommit, oldest.HeadCommit)
+	}
+
+	return fmt.Sprintf("no deploy of the target commit or one of its ancestors found in the history %s", window)
+}
+
+// writePlan writes the plan in the given format, one of text, json or yaml
+func writePlan(w io.Writer, plan *Plan, format string) error {
+	return writeDocument(w, format, plan, func(w io.Writer) error {
+		return writePlanText(w, plan)
+	})
+}
+
+// planFormat returns the format of a plan file from its extension
+func planFormat(file string) string {
+
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// savePlan writes the plan to a file so that it can be applied later
+func savePlan(file string, plan *Plan) error {
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := writePlan(f, plan, planFormat(file)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// loadPlan reads a plan saved by savePlan and checks it can be applied
+func loadPlan(file string) (*Plan, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	if planFormat(file) == "yaml" {
+		err = yaml.Unmarshal(data, plan)
+	} else {
+		err = json.Unmarshal(data, plan)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", file, err)
+	}
+
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d, expected %d", plan.Version, PlanVersion)
+	}
+
+	// Plans saved before the strategy and the revert scope existed revert whole commits
+	if plan.Strategy == "" {
+		plan.Strategy = StrategyRevert
+	}
+	if plan.Strategy != StrategyRevert && plan.Strategy != StrategyRestore {
+		return nil, fmt.Errorf("plan %s has an unknown strategy %q", plan.ID, plan.Strategy)
+	}
+	if plan.Scope == "" {
+		plan.Scope = ScopeCommit
+	}
+	if plan.Scope != ScopeCommit && plan.Scope != ScopePath {
+		return nil, fmt.Errorf("plan %s has an unknown revert scope %q", plan.ID, plan.Scope)
+	}
+
+	if plan.Owner == "" || plan.Repo == "" {
+		return nil, fmt.Errorf("plan %s has no repository", plan.ID)
+	}
+
+	for _, branch := range plan.Branches {
+		if branch.Head == "" {
+			return nil, fmt.Errorf("plan %s has no head commit for branch %s", plan.ID, branch.Branch)
+		}
+		if len(branch.Commits) == 0 {
+			return nil, fmt.Errorf("plan %s has no commits to revert for branch %s", plan.ID, branch.Branch)
+		}
+	}
+
+	return plan, nil
+}
+
+func writePlanText(w io.Writer, plan *Plan) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Plan:\t%s (version %d)\n", plan.ID, plan.Version)
+	fmt.Fprintf(tw, "Repository:\t%s/%s\n", plan.Owner, plan.Repo)
+	fmt.Fprintf(tw, "Paths:\t%s\n", strings.Join(plan.Paths, ", "))
+	fmt.Fprintf(tw, "Lookback window:\t%s, history %s\n", plan.Window.Spec, plan.Window)
+	if plan.RollForward != "" {
+		fmt.Fprintf(tw, "Rolls forward:\t%s\n", plan.RollForward)
+	} else {
+		fmt.Fprintf(tw, "Target:\t%s (%s, %s walk)\n", plan.Target.SHA, plan.Target.Date.Format(time.RFC3339), plan.Target.Walk)
+		fmt.Fprintf(tw, "Selected by:\t%s\n", plan.Target.Selector)
+	}
+	fmt.Fprintf(tw, "Selected branches:\t%d (include %s, exclude %s, protection %s)\n", len(plan.Selection.Branches), strings.Join(plan.Selection.Include, ","), strings.Join(plan.Selection.Exclude, ","), plan.Selection.Protection)
+	for _, excluded := range plan.Selection.Excluded {
+		fmt.Fprintf(tw, "  excluded %s\t%s\n", excluded.Branch, excluded.Reason)
+	}
+	fmt.Fprintf(tw, "Strategy:\t%s\n", plan.Strategy)
+	if plan.Strategy == StrategyRevert {
+		fmt.Fprintf(tw, "Revert scope:\t%s\n", plan.Scope)
+	}
+	if plan.RollForward != "" {
+		fmt.Fprintf(tw, "Branches to roll forward:\t%d\n", len(plan.Branches))
+	} else {
+		fmt.Fprintf(tw, "Branches to roll back:\t%d\n", len(plan.Branches))
+	}
+
+	for _, branch := range plan.Branches {
+		fmt.Fprintf(tw, "\nBranch:\t%s\n", branch.Branch)
+		fmt.Fprintf(tw, "Head:\t%s\n", branch.Head)
+		fmt.Fprintf(tw, "Protected:\t%s\n", protectionStatus(branch.Protected))
+		if len(branch.RequiredChecks) > 0 {
+			fmt.Fprintf(tw, "Required checks:\t%s\n", strings.Join(branch.RequiredChecks, ", "))
+		}
+		fmt.Fprintf(tw, "Anchor:\t%s (deploys %s)\n", branch.Anchor.GitOpsCommit, branch.Anchor.HeadCommit)
+		if plan.Strategy == StrategyRestore {
+			fmt.Fprintf(tw, "Paths to restore:\t%s\n", strings.Join(commitPaths(branch.Commits), ", "))
+			fmt.Fprintf(tw, "Commits superseded:\t%d\n", len(branch.Commits))
+		} else {
+			fmt.Fprintf(tw, "Commits to revert:\t%d\n", len(branch.Commits))
+		}
+		for i, commit := range branch.Commits {
+			fmt.Fprintf(tw, "  %d.\t%s\t%s\t%s\t%s\t%s\n", i+1, commit.SHA, commit.Date.Format(time.RFC3339), commit.Author, strings.Join(commit.Paths, ","), firstLine(commit.Message))
+			if len(commit.LeftAlone) > 0 {
+				fmt.Fprintf(tw, "    \tleft alone: %s\n", strings.Join(commit.LeftAlone, ","))
+			}
+		}
+	}
+
+	if len(plan.Skipped) > 0 {
+		fmt.Fprintf(tw, "\nSkipped branches:\t%d\n", len(plan.Skipped))
+		for _, skipped := range plan.Skipped {
+			fmt.Fprintf(tw, "  %s\t%s\n", skipped.Branch, skipped.Reason)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// firstLine returns the subject line of a commit message
+func firstLine(message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+	return subject
+}
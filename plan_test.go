@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBuildPlan(t *testing.T) {
+
+	target := &HeadCommit{SHA: "1111111111111111111111111111111111111111", Date: time.Now()}
+	branches := []GitOpsBranch{
+		{Name: "gitops/worker", Head: "w3"},
+		{Name: "gitops/api", Head: "a3"},
+		{Name: "gitops/new", Head: "n1"},
+		{Name: "gitops/stable", Head: "s1"},
+	}
+	anchors := &AnchorResult{
+		Anchors: map[string]RollbackCommit{
+			"gitops/api":    {GitOpsCommit: "a1", HeadCommit: target.SHA},
+			"gitops/worker": {GitOpsCommit: "w1", HeadCommit: target.SHA},
+			"gitops/stable": {GitOpsCommit: "s1", HeadCommit: target.SHA},
+		},
+		NeverDeployed: []string{"gitops/new"},
+	}
+	commitsAfterRollback := map[string][]GitOpsCommit{
+		"gitops/api":    {{SHA: "a3", Message: "Deploy 3\n\nbody"}, {SHA: "a2"}},
+		"gitops/worker": {{SHA: "w3"}},
+		"gitops/stable": {},
+	}
+
+	plan := buildPlan("trivago", "hotel-search-web", []string{"manifests/api/prod"}, Window{Spec: "1"}, target, branches, anchors, commitsAfterRollback)
+
+	if plan.Version != PlanVersion || plan.Target.SHA != target.SHA || !strings.HasSuffix(plan.ID, "-1111111") {
+		t.Fatalf("Unexpected plan header: %+v", plan)
+	}
+
+	if len(plan.Branches) != 2 || plan.Branches[0].Branch != "gitops/api" || plan.Branches[1].Branch != "gitops/worker" {
+		t.Fatalf("Unexpected branches in plan: %+v", plan.Branches)
+	}
+
+	if plan.Branches[0].Head != "a3" || plan.Branches[0].Anchor.GitOpsCommit != "a1" || len(plan.Branches[0].Commits) != 2 {
+		t.Errorf("Unexpected plan for gitops/api: %+v", plan.Branches[0])
+	}
+
+	if len(plan.Skipped) != 2 || plan.Skipped[0].Branch != "gitops/new" || plan.Skipped[1].Branch != "gitops/stable" {
+		t.Errorf("Unexpected skipped branches: %+v", plan.Skipped)
+	}
+
+	if !strings.HasPrefix(plan.Skipped[0].Reason, "no deploy of any master commit") {
+		t.Errorf("Unexpected reason for the never deployed branch: %s", plan.Skipped[0].Reason)
+	}
+
+	for _, format := range []string{"json", "yaml", "text"} {
+		var buf bytes.Buffer
+		if err := writePlan(&buf, plan, format); err != nil {
+			t.Fatalf("Failed to write %s plan: %v", format, err)
+		}
+
+		var decoded Plan
+		switch format {
+		case "json":
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("Failed to decode json plan: %v", err)
+			}
+		case "yaml":
+			if err := yaml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("Failed to decode yaml plan: %v", err)
+			}
+		case "text":
+			if !strings.Contains(buf.String(), "Deploy 3") || strings.Contains(buf.String(), "body") {
+				t.Errorf("Text plan should contain commit subjects only:\n%s", buf.String())
+			}
+			continue
+		}
+
+		if decoded.ID != plan.ID || len(decoded.Branches) != 2 || decoded.Branches[0].Commits[0].SHA != "a3" {
+			t.Errorf("Plan did not round-trip through %s: %+v", format, decoded)
+		}
+	}
+
+	if err := writePlan(&bytes.Buffer{}, plan, "xml"); err == nil {
+		t.Errorf("Expected an error for an unknown output format")
+	}
+}
+
+func TestSaveAndLoadPlan(t *testing.T) {
+
+	plan := &Plan{
+		Version: PlanVersion,
+		ID:      "20261016T120000Z-1111111",
+		Owner:   "trivago",
+		Repo:    "hotel-search-web",
+		Branches: []BranchPlan{
+			{Branch: "gitops/api", Head: "a3", Commits: []GitOpsCommit{{SHA: "a3"}, {SHA: "a2"}}},
+		},
+	}
+
+	for _, file := range []string{"plan.json", "plan.yaml"} {
+		path := filepath.Join(t.TempDir(), file)
+		if err := savePlan(path, plan); err != nil {
+			t.Fatalf("Failed to save plan: %v", err)
+		}
+
+		loaded, err := loadPlan(path)
+		if err != nil {
+			t.Fatalf("Failed to load plan: %v", err)
+		}
+
+		if got := commitSHAs(loaded.Branches[0].Commits); !slices.Equal(got, []string{"a3", "a2"}) {
+			t.Errorf("Unexpected commits loaded from %s: %v", file, got)
+		}
+	}
+
+	plan.Version = PlanVersion + 1
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := savePlan(path, plan); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	if _, err := loadPlan(path); err == nil {
+		t.Errorf("Expected an error when loading a plan with an unsupported version")
+	}
+}
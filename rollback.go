@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Revert scopes of a gitops commit
+const (
+	ScopeCommit = "commit"
+	ScopePath   = "path"
+)
+
+// Rollback strategies of a gitops branch
+const (
+	// StrategyRevert reverts the commits after the anchor one by one
+	StrategyRevert = "revert"
+	// StrategyRestore restores the paths to their state at the anchor in a single commit
+	StrategyRestore = "restore"
+)
+
+// Delivery modes of the rollback commits
+const (
+	// DeliverLocal keeps the rollback commits in the clone
+	DeliverLocal = "local"
+	// DeliverPush pushes the rollback commits to the gitops branches
+	DeliverPush = "push"
+	// DeliverPR pushes the rollback commits to a rollback branch and opens a pull request into the gitops branch
+	DeliverPR = "pr"
+)
+
+// Delivery is how the rollback commits of the branches reach the remote repository
+type Delivery struct {
+	Mode        string
+	PullRequest PullRequestOptions
+	// Atomic prepares every branch before delivering any, and delivers them in a single atomic push
+	Atomic bool
+	// Freeze disables the Workflows and cancels the pending runs of the branches before delivering,
+	// recording the changes in the FreezeState file
+	Freeze      bool
+	Workflows   []string
+	FreezeState string
+	// Wait waits up to WaitTimeout for the workflow runs of the pushed branches
+	Wait        bool
+	WaitTimeout time.Duration
+	// client opens the pull requests of the pr mode, freezes the deploys and waits for them
+	client *GithubClient
+	// frozen are the IDs of the workflows disabled by the freeze, their runs aren't waited for
+	frozen map[int64]bool
+}
+
+// newDelivery returns the delivery of the options, the pr mode, the freeze and the wait need a GitHub client
+func newDelivery(opts *Options, owner, repo string) (*Delivery, error) {
+
+	delivery := &Delivery{
+		Mode:   opts.Deliver,
+		Atomic: opts.Atomic,
+		PullRequest: PullRequestOptions{
+			Reviewers: opts.Reviewers,
+			Labels:    opts.Labels,
+			AutoMerge: opts.AutoMerge,
+		},
+		Freeze:      opts.Freeze,
+		Workflows:   opts.Workflows,
+		FreezeState: opts.FreezeState,
+		Wait:        opts.Wait,
+		WaitTimeout: opts.DeployTimeout,
+	}
+
+	if delivery.Freeze && delivery.Mode == DeliverLocal {
+		log.Printf("Skipping the deploy freeze, delivery is local")
+		delivery.Freeze = false
+	}
+
+	if delivery.Wait && delivery.Mode != DeliverPush {
+		log.Printf("Skipping the wait for the deploys, delivery is %s", delivery.Mode)
+		delivery.Wait = false
+	}
+
+	if delivery.Mode == DeliverPR || delivery.Freeze || delivery.Wait {
+		client, err := NewGithubClient(owner, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the github client opening the pull requests or handling the deploys: %w", err)
+		}
+		delivery.client = client
+	}
+
+	return delivery, nil
+}
+
+// rollbackBranchName is the branch the rollback commits of a gitops branch are pushed to in the pr mode
+func rollbackBranchName(plan *Plan, branch string) string {
+	if plan.RollForward != "" {
+		return fmt.Sprintf("roll-forward/%s/%s", branch, plan.ID)
+	}
+	return fmt.Sprintf("rollback/%s/%s", branch, plan.ID)
+}
+
+// pullRequestText returns the title and body of the pull request rolling a gitops branch back
+func pullRequestText(plan *Plan, branchPlan BranchPlan) (title, body string) {
+
+	var b strings.Builder
+	if plan.RollForward != "" {
+		title = fmt.Sprintf("Roll %s forward, undoing rollback %s", branchPlan.Branch, plan.RollForward)
+		fmt.Fprintf(&b, "Roll-forward plan `%s` undoes rollback `%s` on `%s`.\n\n", plan.ID, plan.RollForward, branchPlan.Branch)
+	} else {
+		title = fmt.Sprintf("Roll back %s to %.7s", branchPlan.Branch, plan.Target.SHA)
+		fmt.Fprintf(&b, "Rollback plan `%s` rolls `%s` back to `%s` (%s).\n\n", plan.ID, branchPlan.Branch, plan.Target.SHA, plan.Target.Selector)
+	}
+	fmt.Fprintf(&b, "- Anchor: `%s`, deploying `%s`\n", branchPlan.Anchor.GitOpsCommit, branchPlan.Anchor.HeadCommit)
+	fmt.Fprintf(&b, "- Paths: `%s`\n", strings.Join(plan.Paths, "`, `"))
+	fmt.Fprintf(&b, "- Strategy: %s", plan.Strategy)
+	if plan.Strategy == StrategyRevert {
+		fmt.Fprintf(&b, ", %s scope", plan.Scope)
+	}
+	fmt.Fprintf(&b, "\n\n")
+
+	if plan.Strategy == StrategyRestore {
+		fmt.Fprintf(&b, "Superseded commits:\n\n")
+	} else {
+		fmt.Fprintf(&b, "Reverted commits:\n\n")
+	}
+	for _, commit := range branchPlan.Commits {
+		fmt.Fprintf(&b, "- %s %s", commit.SHA, firstLine(commit.Message))
+		if len(commit.LeftAlone) > 0 {
+			fmt.Fprintf(&b, " (left alone: `%s`)", strings.Join(commit.LeftAlone, "`, `"))
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+
+	return title, b.String()
+}
+
+// deliverBranch delivers the rollback commits of a branch and records the outcome in its result
+func (d *Delivery) deliverBranch(plan *Plan, branchPlan BranchPlan, repoDir string, result *BranchResult) error {
+
+	branch := branchPlan.Branch
+
+	if d.Mode == DeliverLocal {
+		log.Printf("Skipping push of changes to remote repository, delivery is local")
+		return nil
+	}
+
+	head, err := localBranchHead(repoDir, branch)
+	if err != nil {
+		return err
+	}
+	if head == branchPlan.Head {
+		log.Printf("No rollback commit on branch %s, nothing to deliver", branch)
+		result.Status = StatusSkipped
+		result.Reason = "no rollback commit, already at the target state"
+		return nil
+	}
+
+	if d.Mode == DeliverPush {
+		if err := pushRefCLI(repoDir, branch, branch); err != nil {
+			return err
+		}
+		result.Status = StatusPushed
+		return nil
+	}
+
+	prBranch := rollbackBranchName(plan, branch)
+	if err := pushRefCLI(repoDir, branch, prBranch); err != nil {
+		return err
+	}
+	result.Status = StatusPushed
+
+	result.PullRequest, err = d.openPullRequest(plan, branchPlan, prBranch)
+	return err
+}
+
+// openPullRequest opens the pull request of the rollback branch into the gitops branch and returns its URL
+func (d *Delivery) openPullRequest(plan *Plan, branchPlan BranchPlan, prBranch string) (string, error) {
+
+	title, body := pullRequestText(plan, branchPlan)
+	pr, err := d.client.CreatePullRequest(context.Background(), prBranch, branchPlan.Branch, title, body, d.PullRequest)
+	if pr == nil {
+		return "", err
+	}
+	if err != nil {
+		log.Printf("Opened pull request %s for branch %s, but: %v", pr.GetHTMLURL(), branchPlan.Branch, err)
+	}
+
+	return pr.GetHTMLURL(), nil
+}
+
+// deliverAtomic delivers the prepared branches in a single atomic push, only if every branch was prepared.
+// Otherwise, or if the push fails, the prepared branches are reset to their head in the clone.
+func (d *Delivery) deliverAtomic(plan *Plan, results []BranchResult, ready []bool, repoDir string) {
+
+	failed := make([]string, 0)
+	for i, result := range results {
+		if !ready[i] {
+			failed = append(failed, result.Branch)
+		}
+	}
+
+	if len(failed) > 0 {
+		d.abortAtomic(plan, results, ready, repoDir, fmt.Sprintf("branches %s could not be rolled back", strings.Join(failed, ", ")))
+		return
+	}
+
+	refs := make([]atomicRef, 0, len(plan.Branches))
+	for i, branchPlan := range plan.Branches {
+		head, err := localBranchHead(repoDir, branchPlan.Branch)
+		if err != nil {
+			d.abortAtomic(plan, results, ready, repoDir, err.Error())
+			return
+		}
+		if head == branchPlan.Head {
+			log.Printf("No rollback commit on branch %s, nothing to deliver", branchPlan.Branch)
+			if d.Mode != DeliverLocal {
+				results[i].Status = StatusSkipped
+				results[i].Reason = "no rollback commit, already at the target state"
+			}
+			continue
+		}
+
+		// The gitops branch must still be at the head of the plan, a rollback branch must not exist yet
+		ref := atomicRef{Branch: branchPlan.Branch, RemoteBranch: branchPlan.Branch, Expected: branchPlan.Head}
+		if d.Mode == DeliverPR {
+			ref.RemoteBranch = rollbackBranchName(plan, branchPlan.Branch)
+			ref.Expected = ""
+		}
+		refs = append(refs, ref)
+	}
+
+	if d.Mode == DeliverLocal {
+		log.Printf("Every branch was rolled back, skipping push of changes to remote repository, delivery is local")
+		return
+	}
+
+	if len(refs) == 0 {
+		return
+	}
+
+	log.Printf("Pushing the rollback of %d branches atomically", len(refs))
+	if err := pushAtomicCLI(repoDir, refs); err != nil {
+		d.abortAtomic(plan, results, ready, repoDir, err.Error())
+		return
+	}
+
+	for i, branchPlan := range plan.Branches {
+		for _, ref := range refs {
+			if ref.Branch != branchPlan.Branch {
+				continue
+			}
+			results[i].Status = StatusPushed
+			if d.Mode != DeliverPR {
+				continue
+			}
+
+			url, err := d.openPullRequest(plan, branchPlan, ref.RemoteBranch)
+			if err != nil {
+				log.Printf("Failed to open the pull request of branch %s: %v", branchPlan.Branch, err)
+				results[i].fail(err.Error())
+			}
+			results[i].PullRequest = url
+		}
+	}
+}
+
+// abortAtomic resets the prepared branches to their head in the clone, nothing is delivered
+func (d *Delivery) abortAtomic(plan *Plan, results []BranchResult, ready []bool, repoDir string, reason string) {
+
+	log.Printf("Atomic rollback aborted, nothing is delivered: %s", reason)
+
+	for i, branchPlan := range plan.Branches {
+		if !ready[i] {
+			continue
+		}
+		if err := resetBranchCLI(repoDir, branchPlan.Branch, branchPlan.Head); err != nil {
+			log.Printf("Failed to reset branch %s: %v", branchPlan.Branch, err)
+		}
+		results[i].fail("reset in the clone, the atomic rollback was aborted: " + reason)
+	}
+}
+
+// Handling of a conflicting revert of a gitops branch
+const (
+	// OnConflictSkip leaves the branch at its head
+	OnConflictSkip = "skip"
+	// OnConflictRestore retries the branch with the restore strategy
+	OnConflictRestore = "restore"
+)
+
+// Outcomes of the rollback of a gitops branch
+const (
+	// StatusReverted is a branch rolled back in the clone only
+	StatusReverted = "reverted"
+	// StatusPushed is a branch whose rollback was pushed, directly or to a pull request
+	StatusPushed = "pushed"
+	// StatusSkipped is a branch left untouched, skipped by the plan or already at the target state
+	StatusSkipped = "skipped"
+	// StatusConflicted is a branch whose revert conflicted and was skipped
+	StatusConflicted = "conflicted"
+	// StatusFailed is a branch whose rollback or delivery failed
+	StatusFailed = "failed"
+)
+
+// BranchResult is the outcome of the rollback of a gitops branch
+type BranchResult struct {
+	Branch string `json:"branch" yaml:"branch"`
+	Status string `json:"status" yaml:"status"`
+	// Strategy the branch was rolled back with, restore when a conflicting revert was retried
+	Strategy    string         `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	Conflict    *ConflictError `json:"conflict,omitempty" yaml:"conflict,omitempty"`
+	PullRequest string         `json:"pullRequest,omitempty" yaml:"pullRequest,omitempty"`
+	// Reason a branch was skipped
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+	// Deploy is the outcome of the workflow runs of the pushed rollback, when waited for
+	Deploy string      `json:"deploy,omitempty" yaml:"deploy,omitempty"`
+	Runs   []DeployRun `json:"runs,omitempty" yaml:"runs,omitempty"`
+}
+
+// fail marks the branch as failed with an error
+func (r *BranchResult) fail(err string) {
+	r.Status = StatusFailed
+	r.Error = err
+}
+
+// rollbackBranch rolls a branch back with a strategy and the revert scope of the plan
+func rollbackBranch(plan *Plan, branchPlan BranchPlan, strategy string, repoDir string) error {
+
+	switch {
+	case strategy == StrategyRestore:
+		return restorePathsCLI(repoDir, branchPlan.Branch, branchPlan.Anchor, commitPaths(branchPlan.Commits), branchPlan.Commits, planTrailer(plan), true)
+	case plan.Scope == ScopePath:
+		return revertPathsCLI(repoDir, branchPlan.Branch, branchPlan.Commits, planTrailer(plan), true)
+	default:
+		return revertFromCommitCLI(repoDir, branchPlan.Branch, commitSHAs(branchPlan.Commits), planTrailer(plan), true)
+	}
+}
+
+// prepareBranch rolls a branch back in the clone with the strategy of the plan, retrying a conflicting revert
+// with the restore strategy if asked to. It reports whether the branch is ready to be delivered.
+func prepareBranch(plan *Plan, branchPlan BranchPlan, repoDir string, onConflict string) (BranchResult, bool) {
+
+	branch := branchPlan.Branch
+	result := BranchResult{Branch: branch, Strategy: plan.Strategy}
+
+	log.Printf("Rolling back %d commits on branch %s with the %s strategy", len(branchPlan.Commits), branch, plan.Strategy)
+	err := rollbackBranch(plan, branchPlan, plan.Strategy, repoDir)
+
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		result.Conflict = conflict
+		log.Printf("Aborted the revert of branch %s: %v", branch, conflict)
+
+		if onConflict != OnConflictRestore {
+			log.Printf("Skipping branch %s after the conflict", branch)
+			result.Status = StatusConflicted
+			return result, false
+		}
+
+		log.Printf("Retrying branch %s with the restore strategy", branch)
+		result.Strategy = StrategyRestore
+		err = rollbackBranch(plan, branchPlan, StrategyRestore, repoDir)
+	}
+
+	if err != nil {
+		log.Printf("Failed to revert commits on branch %s: %v", branch, err)
+		result.fail(err.Error())
+		return result, false
+	}
+
+	result.Status = StatusReverted
+	return result, true
+}
+
+// executeBranch rolls a branch back and delivers the rollback commits
+func executeBranch(plan *Plan, branchPlan BranchPlan, repoDir string, onConflict string, delivery *Delivery) BranchResult {
+
+	result, ready := prepareBranch(plan, branchPlan, repoDir, onConflict)
+	if !ready {
+		return result
+	}
+
+	if err := delivery.deliverBranch(plan, branchPlan, repoDir, &result); err != nil {
+		log.Printf("Failed to deliver the rollback of branch %s: %v", branchPlan.Branch, err)
+		result.fail(err.Error())
+	}
+
+	return result
+}
+
+// executePlan rolls back every branch in the plan, one worker per branch, and delivers the rollback commits.
+// An atomic delivery waits for every branch to be rolled back first. It returns the result of every branch,
+// including the ones skipped by the plan, sorted by branch.
+func executePlan(plan *Plan, repoDir string, onConflict string, delivery *Delivery) []BranchResult {
+
+	var wg sync.WaitGroup
+	results := make([]BranchResult, len(plan.Branches))
+	ready := make([]bool, len(plan.Branches))
+
+	concurrencyLimit := 20
+	if len(plan.Branches) < concurrencyLimit {
+		concurrencyLimit = len(plan.Branches)
+	}
+
+	for _, skipped := range plan.Skipped {
+		log.Printf("Skipping branch %s: %s", skipped.Branch, skipped.Reason)
+	}
+
+	// Nothing is rolled back if the deploys could not be frozen
+	var freezeErr error
+	if delivery.Freeze && len(plan.Branches) > 0 {
+		if freezeErr = delivery.freeze(plan); freezeErr != nil {
+			log.Printf("Failed to freeze the deploys, nothing is rolled back: %v", freezeErr)
+		}
+	}
+
+	sem := make(chan struct{}, concurrencyLimit)
+	for i, branchPlan := range plan.Branches {
+		if freezeErr != nil {
+			results[i] = BranchResult{Branch: branchPlan.Branch, Strategy: plan.Strategy}
+			results[i].fail("deploys could not be frozen: " + freezeErr.Error())
+			continue
+		}
+
+		// Create a worker per branch that has commits to process
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, branchPlan BranchPlan) {
+			defer func() { <-sem; wg.Done() }()
+			log.Printf("------------ START BRANCH %s-------------\n", branchPlan.Branch)
+			if delivery.Atomic {
+				results[i], ready[i] = prepareBranch(plan, branchPlan, repoDir, onConflict)
+			} else {
+				results[i] = executeBranch(plan, branchPlan, repoDir, onConflict, delivery)
+			}
+			log.Printf("------------ END BRANCH %s-------------\n", branchPlan.Branch)
+		}(i, branchPlan)
+	}
+	wg.Wait()
+
+	if delivery.Atomic && freezeErr == nil {
+		delivery.deliverAtomic(plan, results, ready, repoDir)
+	}
+
+	if delivery.Wait {
+		delivery.waitDeploys(results, repoDir)
+	}
+
+	for _, skipped := range plan.Skipped {
+		results = append(results, BranchResult{Branch: skipped.Branch, Status: StatusSkipped, Reason: skipped.Reason})
+	}
+
+	slices.SortFunc(results, func(x, y BranchResult) int { return strings.Compare(x.Branch, y.Branch) })
+
+	for _, result := range results {
+		if result.Conflict != nil {
+			log.Printf("Conflict report of branch %s: reverting %s conflicts in %s, %s", result.Branch, result.Conflict.Commit, strings.Join(result.Conflict.Files, ", "), conflictOutcome(result))
+		}
+	}
+
+	pullRequests := 0
+	for _, result := range results {
+		if result.PullRequest != "" {
+			pullRequests++
+		}
+	}
+	if pullRequests > 0 {
+		log.Printf("Opened %d rollback pull requests:", pullRequests)
+		for _, result := range results {
+			if result.PullRequest != "" {
+				log.Printf("  %s: %s", result.Branch, result.PullRequest)
+			}
+		}
+	}
+
+	return results
+}
+
+// conflictOutcome describes what happened to a branch after a conflict
+func conflictOutcome(result BranchResult) string {
+	switch {
+	case result.Status == StatusConflicted:
+		return "skipped"
+	case result.Status == StatusFailed:
+		return "restore failed: " + result.Error
+	default:
+		return "restored instead"
+	}
+}
+
+// writeSummary writes the table of the outcome of every branch, then the workflow runs of the deploys waited for
+func writeSummary(w io.Writer, results []BranchResult) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "BRANCH\tSTATUS\tSTRATEGY\tDEPLOY\tDETAIL\n")
+	runs := 0
+	for _, result := range results {
+		strategy, deploy := result.Strategy, result.Deploy
+		if strategy == "" {
+			strategy = "-"
+		}
+		if deploy == "" {
+			deploy = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result.Branch, result.Status, strategy, deploy, resultDetail(result))
+		runs += len(result.Runs)
+	}
+
+	if runs > 0 {
+		fmt.Fprintf(tw, "\nBRANCH\tWORKFLOW\tCONCLUSION\tURL\n")
+		for _, result := range results {
+			for _, run := range result.Runs {
+				conclusion := run.Conclusion
+				if conclusion == "" {
+					conclusion = run.Status
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Branch, run.Workflow, conclusion, run.URL)
+			}
+		}
+	}
+
+	return tw.Flush()
+}
+
+// resultDetail describes the outcome of a branch in a single line
+func resultDetail(result BranchResult) string {
+	switch {
+	case result.Error != "":
+		return result.Error
+	case result.Status == StatusConflicted && result.Conflict != nil:
+		return fmt.Sprintf("reverting %s conflicts in %s", result.Conflict.Commit, strings.Join(result.Conflict.Files, ", "))
+	case result.Reason != "":
+		return result.Reason
+	case result.PullRequest != "":
+		return result.PullRequest
+	case result.Conflict != nil:
+		return fmt.Sprintf("restored after reverting %s conflicted", result.Conflict.Commit)
+	default:
+		return "-"
+	}
+}
+
+// Exit codes of a rollback whose branches didn't all succeed
+const (
+	ExitPartialFailure = 3
+	ExitTotalFailure   = 4
+	ExitNothingToDo    = 5
+)
+
+// resultsError returns the error ending a rollback with the exit code of its results, nil if every branch was rolled back
+func resultsError(results []BranchResult) error {
+
+	code := resultsExitCode(results)
+	switch code {
+	case ExitPartialFailure:
+		return &exitError{code: code, err: fmt.Errorf("partial failure, some branches failed, conflicted or failed to deploy")}
+	case ExitTotalFailure:
+		return &exitError{code: code, err: fmt.Errorf("total failure, no branch was rolled back successfully")}
+	case ExitNothingToDo:
+		return &exitError{code: code, err: fmt.Errorf("nothing to do, every branch was skipped")}
+	default:
+		return nil
+	}
+}
+
+// resultsExitCode returns the exit code of a rollback: 0 if every branch was rolled back, a partial failure
+// if some branches failed, conflicted or failed to deploy, a total failure if all did, and nothing to do if every branch was skipped
+func resultsExitCode(results []BranchResult) int {
+
+	succeeded, failed := 0, 0
+	for _, result := range results {
+		switch {
+		case result.Deploy == DeployFailed || result.Deploy == DeployTimedOut:
+			failed++
+		case result.Status == StatusReverted || result.Status == StatusPushed:
+			succeeded++
+		case result.Status == StatusConflicted || result.Status == StatusFailed:
+			failed++
+		}
+	}
+
+	switch {
+	case failed > 0 && succeeded > 0:
+		return ExitPartialFailure
+	case failed > 0:
+		return ExitTotalFailure
+	case succeeded == 0:
+		return ExitNothingToDo
+	default:
+		return 0
+	}
+}
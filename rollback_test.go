@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v71/github"
+)
+
+func TestDeliverBranchToRollbackBranch(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	anchor := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	head := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+	repoDir := cloneFixture(t, f)
+
+	plan := &Plan{ID: "fixture", Strategy: StrategyRestore}
+	branchPlan := BranchPlan{
+		Branch:  "gitops/api",
+		Head:    head,
+		Anchor:  RollbackCommit{GitOpsCommit: anchor},
+		Commits: []GitOpsCommit{{SHA: head, Message: "Deploy v2", Paths: []string{path}}},
+	}
+
+	if err := rollbackBranch(plan, branchPlan, plan.Strategy, repoDir); err != nil {
+		t.Fatalf("Failed to roll back the branch: %v", err)
+	}
+
+	// The fixture has gitops/api checked out, so it only accepts a push to another branch
+	prBranch := rollbackBranchName(plan, branchPlan.Branch)
+	if prBranch != "rollback/gitops/api/fixture" {
+		t.Errorf("Unexpected rollback branch %s", prBranch)
+	}
+	if err := pushRefCLI(repoDir, branchPlan.Branch, prBranch); err != nil {
+		t.Fatalf("Failed to push the rollback branch: %v", err)
+	}
+
+	if got := gitOutput(t, f.dir, "show", prBranch+":"+path+"/deployment.yaml"); got != "v1" {
+		t.Errorf("Expected the rollback branch to restore v1, got %q", got)
+	}
+
+	// Nothing is delivered when the rollback made no commit
+	branchPlan.Head = strings.TrimSpace(gitOutput(t, repoDir, "rev-parse", "gitops/api"))
+	delivery := &Delivery{Mode: DeliverPR}
+	result := BranchResult{Branch: branchPlan.Branch, Status: StatusReverted}
+	if err := delivery.deliverBranch(plan, branchPlan, repoDir, &result); err != nil || result.Status != StatusSkipped || result.PullRequest != "" {
+		t.Errorf("Expected nothing to deliver, got %+v, %v", result, err)
+	}
+}
+
+func TestPullRequestText(t *testing.T) {
+
+	plan := &Plan{
+		ID:       "20261016T120000Z-1111111",
+		Paths:    []string{"manifests/api/prod"},
+		Target:   PlanTarget{SHA: "1111111111111111111111111111111111111111", Selector: "tag v1.42.0"},
+		Strategy: StrategyRevert,
+		Scope:    ScopePath,
+	}
+	branchPlan := BranchPlan{
+		Branch: "gitops/api",
+		Anchor: RollbackCommit{GitOpsCommit: "a1", HeadCommit: plan.Target.SHA},
+		Commits: []GitOpsCommit{
+			{SHA: "a3", Message: "Deploy v3\n\nbody", LeftAlone: []string{"manifests/worker/prod/deployment.yaml"}},
+			{SHA: "a2", Message: "Deploy v2"},
+		},
+	}
+
+	title, body := pullRequestText(plan, branchPlan)
+
+	if title != "Roll back gitops/api to 1111111" {
+		t.Errorf("Unexpected title %q", title)
+	}
+
+	for _, want := range []string{plan.ID, "tag v1.42.0", "revert, path scope", "- a3 Deploy v3 (left alone: `manifests/worker/prod/deployment.yaml`)", "- a2 Deploy v2\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the body to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+
+	var reviewers github.ReviewersRequest
+	var labels []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /repos/trivago/gitops/pulls", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"number": 12, "node_id": "PR_12", "html_url": "https://github.com/trivago/gitops/pull/12"}`)
+	})
+	mux.HandleFunc("POST /repos/trivago/gitops/pulls/12/requested_reviewers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reviewers)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"number": 12}`)
+	})
+	mux.HandleFunc("POST /repos/trivago/gitops/issues/12/labels", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&labels)
+		fmt.Fprint(w, `[]`)
+	})
+	// GraphQL reports the errors with a successful status
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errors": [{"message": "Pull request is in clean status"}]}`)
+	})
+	client := newTestGithubClient(t, mux)
+
+	opts := PullRequestOptions{Reviewers: []string{"alice", "trivago/sre"}, Labels: []string{"rollback"}, AutoMerge: true}
+	pr, err := client.CreatePullRequest(context.Background(), "rollback/fixture/gitops/api", "gitops/api", "Roll back gitops/api", "", opts)
+	if pr.GetNumber() != 12 {
+		t.Fatalf("Expected the pull request to be created, got %+v", pr)
+	}
+	if err == nil || !strings.Contains(err.Error(), "failed to enable auto-merge: Pull request is in clean status") {
+		t.Errorf("Expected the auto-merge GraphQL error, got %v", err)
+	}
+
+	if !slices.Equal(reviewers.Reviewers, []string{"alice"}) || !slices.Equal(reviewers.TeamReviewers, []string{"sre"}) {
+		t.Errorf("Unexpected reviewers requested: %+v", reviewers)
+	}
+	if !slices.Equal(labels, []string{"rollback"}) {
+		t.Errorf("Unexpected labels added: %v", labels)
+	}
+}
+
+func TestExecuteBranchConflict(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	anchor := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+	bad := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+	head := f.commit("gitops/api", map[string]string{path + "/deployment.yaml": "v3"}, "Deploy v3")
+	repoDir := cloneFixture(t, f)
+
+	// Only the bad commit is planned, its revert conflicts with the next deploy
+	plan := &Plan{ID: "fixture", Strategy: StrategyRevert, Scope: ScopeCommit}
+	branchPlan := BranchPlan{
+		Branch:  "gitops/api",
+		Head:    head,
+		Anchor:  RollbackCommit{GitOpsCommit: anchor},
+		Commits: []GitOpsCommit{{SHA: bad, Message: "Deploy v2", Paths: []string{path}}},
+	}
+	delivery := &Delivery{Mode: DeliverLocal}
+
+	result := executeBranch(plan, branchPlan, repoDir, OnConflictSkip, delivery)
+	if result.Conflict == nil || result.Status != StatusConflicted || result.Strategy != StrategyRevert || result.Error != "" {
+		t.Fatalf("Expected the branch to be skipped after the conflict, got %+v", result)
+	}
+
+	result = executeBranch(plan, branchPlan, repoDir, OnConflictRestore, delivery)
+	if result.Conflict == nil || result.Status != StatusReverted || result.Strategy != StrategyRestore || result.Error != "" {
+		t.Fatalf("Expected the branch to be restored after the conflict, got %+v", result)
+	}
+
+	if got := gitOutput(t, repoDir, "show", "gitops/api:"+path+"/deployment.yaml"); got != "v1" {
+		t.Errorf("Expected the branch to be restored to v1, got %q", got)
+	}
+}
+
+// cloneBareFixture clones a fixture repository through a bare remote, which accepts pushes to any branch.
+// It returns the clone and the remote.
+func cloneBareFixture(t *testing.T, f *fixtureRepo) (string, string) {
+	t.Helper()
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	gitOutput(t, f.dir, "clone", "--quiet", "--bare", f.dir, remote)
+
+	repoDir := filepath.Join(t.TempDir(), "clone")
+	gitOutput(t, remote, "clone", "--quiet", "--branch", "master", remote, repoDir)
+
+	return repoDir, remote
+}
+
+func TestExecutePlanAtomic(t *testing.T) {
+
+	setGitIdentity(t)
+
+	f := newFixtureRepo(t)
+	path := "manifests/api/prod"
+	plan := &Plan{ID: "fixture", Strategy: StrategyRestore}
+	for _, branch := range []string{"gitops/api", "gitops/worker"} {
+		anchor := f.commit(branch, map[string]string{path + "/deployment.yaml": "v1"}, "Deploy v1")
+		head := f.commit(branch, map[string]string{path + "/deployment.yaml": "v2"}, "Deploy v2")
+		plan.Branches = append(plan.Branches, BranchPlan{
+			Branch:  branch,
+			Head:    head,
+			Anchor:  RollbackCommit{GitOpsCommit: anchor},
+			Commits: []GitOpsCommit{{SHA: head, Message: "Deploy v2", Paths: []string{path}}},
+		})
+	}
+	delivery := &Delivery{Mode: DeliverPush, Atomic: true}
+
+	// gitops/worker moved on the remote since the plan was computed, the push of both branches is refused
+	repoDir, remote := cloneBareFixture(t, f)
+	gitOutput(t, remote, "update-ref", "refs/heads/gitops/worker", f.base.String())
+
+	results := executePlan(plan, repoDir, OnConflictSkip, delivery)
+	for i, result := range results {
+		if result.Status != StatusFailed || !strings.HasPrefix(result.Error, "reset in the clone") {
+			t.Errorf("Expected %s to be reset in the clone, got %+v", result.Branch, result)
+		}
+		if got := strings.TrimSpace(gitOutput(t, repoDir, "rev-parse", result.Branch)); got != plan.Branches[i].Head {
+			t.Errorf("Expected %s to be reset to %s in the clone, got %s", result.Branch, plan.Branches[i].Head, got)
+		}
+	}
+	if got := strings.TrimSpace(gitOutput(t, remote, "rev-parse", "gitops/api")); got != plan.Branches[0].Head {
+		t.Errorf("Expected gitops/api not to be pushed, got %s", got)
+	}
+
+	// Every branch is pushed when none moved
+	repoDir, remote = cloneBareFixture(t, f)
+	results = executePlan(plan, repoDir, OnConflictSkip, delivery)
+	for _, result := range results {
+		if result.Status != StatusPushed {
+			t.Errorf("Expected %s to be pushed, got %+v", result.Branch, result)
+		}
+		if got := gitOutput(t, remote, "show", result.Branch+":"+path+"/deployment.yaml"); got != "v1" {
+			t.Errorf("Expected %s to be restored to v1 on the remote, got %q", result.Branch, got)
+		}
+	}
+}
+
+func TestResultsExitCode(t *testing.T) {
+
+	pushed := BranchResult{Branch: "gitops/api", Status: StatusPushed}
+	skipped := BranchResult{Branch: "gitops/idle", Status: StatusSkipped}
+	conflicted := BranchResult{Branch: "gitops/worker", Status: StatusConflicted, Conflict: &ConflictError{Branch: "gitops/worker", Commit: "1234567", Files: []string{"values.yaml"}}}
+	failed := BranchResult{Branch: "gitops/web", Status: StatusFailed}
+	deployFailed := BranchResult{Branch: "gitops/search", Status: StatusPushed, Deploy: DeployFailed, Runs: []DeployRun{
+		{Workflow: "Deploy", Status: "completed", Conclusion: "failure", URL: "https://github.com/trivago/gitops/actions/runs/7"},
+	}}
+
+	tests := []struct {
+		name    string
+		results []BranchResult
+		want    int
+	}{
+		{name: "success", results: []BranchResult{pushed, skipped}, want: 0},
+		{name: "partial failure", results: []BranchResult{pushed, conflicted, skipped}, want: ExitPartialFailure},
+		{name: "total failure", results: []BranchResult{failed, conflicted, skipped}, want: ExitTotalFailure},
+		{name: "failed deploy", results: []BranchResult{pushed, deployFailed}, want: ExitPartialFailure},
+		{name: "no deploy succeeded", results: []BranchResult{deployFailed, skipped}, want: ExitTotalFailure},
+		{name: "nothing to do", results: []BranchResult{skipped}, want: ExitNothingToDo},
+		{name: "empty plan", results: nil, want: ExitNothingToDo},
+	}
+
+	for _, tt := range tests {
+		if got := resultsExitCode(tt.results); got != tt.want {
+			t.Errorf("%s: resultsExitCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	var summary strings.Builder
+	if err := writeSummary(&summary, []BranchResult{pushed, conflicted, deployFailed}); err != nil {
+		t.Fatalf("Failed to write the summary: %v", err)
+	}
+	if !strings.Contains(summary.String(), "reverting 1234567 conflicts in values.yaml") || !strings.Contains(summary.String(), "failure     https://github.com/trivago/gitops/actions/runs/7") {
+		t.Errorf("Unexpected summary:\n%s", summary.String())
+	}
+}
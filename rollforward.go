@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Trailers identifying the commits written by the tool
+const (
+	// TrailerRollbackID is written on the rollback commits, with the ID of their plan
+	TrailerRollbackID = "Rollback-Id"
+	// TrailerRollForward is written on the roll-forward commits, with the ID of the rollback they undo
+	TrailerRollForward = "Roll-Forward-Of"
+)
+
+// planTrailer returns the trailer of the commits written by a plan
+func planTrailer(plan *Plan) string {
+
+	if plan.RollForward != "" {
+		return fmt.Sprintf("%s: %s", TrailerRollForward, plan.RollForward)
+	}
+
+	return fmt.Sprintf("%s: %s", TrailerRollbackID, plan.ID)
+}
+
+// commitTrailer returns the value of a trailer of a commit message, or an empty string
+func commitTrailer(message, key string) string {
+
+	for _, line := range strings.Split(message, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), key+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// rolledBackIDs returns the IDs of the rollbacks of a branch history that weren't rolled forward yet
+func rolledBackIDs(history []GitOpsCommit) []string {
+
+	rolledForward := make(map[string]bool)
+	for _, commit := range history {
+		if id := commitTrailer(commit.Message, TrailerRollForward); id != "" {
+			rolledForward[id] = true
+		}
+	}
+
+	ids := make([]string, 0)
+	for _, commit := range history {
+		if id := commitTrailer(commit.Message, TrailerRollbackID); id != "" && !rolledForward[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// latestRollback returns the ID of the newest rollback of the branches that wasn't rolled forward yet,
+// plan IDs start with their creation time
+func latestRollback(commitsHistory map[string][]GitOpsCommit) string {
+
+	latest := ""
+	for _, history := range commitsHistory {
+		for _, id := range rolledBackIDs(history) {
+			latest = max(latest, id)
+		}
+	}
+
+	return latest
+}
+
+// buildRollForwardPlan assembles the plan undoing a rollback: on every branch, the commits of the rollback are reverted,
+// or the paths restored to their state right before the rollback
+func buildRollForwardPlan(owner, repo string, paths []string, window Window, rollbackID string, branches []GitOpsBranch, commitsGraph map[string]*HeadCommit, commitsHistory map[string][]GitOpsCommit, matcher *ReferenceMatcher) *Plan {
+
+	createdAt := time.Now().UTC()
+
+	plan := &Plan{
+		Version:     PlanVersion,
+		ID:          fmt.Sprintf("%s-forward", createdAt.Format("20060102T150405Z")),
+		CreatedAt:   createdAt,
+		Owner:       owner,
+		Repo:        repo,
+		Paths:       paths,
+		Window:      window,
+		RollForward: rollbackID,
+		Branches:    make([]BranchPlan, 0),
+		Skipped:     make([]SkippedBranch, 0),
+	}
+
+	for _, branch := range branches {
+		history := commitsHistory[branch.Name]
+
+		// History is newest to oldest, the anchor is the newest deploy older than the rollback
+		commits := make([]GitOpsCommit, 0)
+		oldest := -1
+		for i, commit := range history {
+			if commitTrailer(commit.Message, TrailerRollbackID) == rollbackID {
+				commits = append(commits, commit)
+				oldest = i
+			}
+		}
+
+		if len(commits) == 0 {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: fmt.Sprintf("no commit of rollback %s found in the history %s", rollbackID, window),
+			})
+			continue
+		}
+
+		if !slices.Contains(rolledBackIDs(history), rollbackID) {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: fmt.Sprintf("rollback %s was already rolled forward", rollbackID),
+			})
+			continue
+		}
+
+		if oldest+1 == len(history) {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: fmt.Sprintf("no commit before rollback %s found in the history %s", rollbackID, window),
+			})
+			continue
+		}
+
+		// The anchor is the state of the branch right before the rollback, with the newest deploy of a master commit up to it
+		anchor := RollbackCommit{GitOpsCommit: history[oldest+1].SHA}
+		for _, commit := range history[oldest+1:] {
+			if isRollbackCommit(commit.Message) {
+				continue
+			}
+			if sha := matcher.Resolve(commit.Message, commitsGraph); sha != "" {
+				anchor.HeadCommit = sha
+				break
+			}
+		}
+
+		if anchor.HeadCommit == "" {
+			plan.Skipped = append(plan.Skipped, SkippedBranch{
+				Branch: branch.Name,
+				Reason: fmt.Sprintf("no deploy of a master commit before rollback %s found in the history %s", rollbackID, window),
+			})
+			continue
+		}
+
+		plan.Branches = append(plan.Branches, BranchPlan{
+			Branch:         branch.Name,
+			Head:           branch.Head,
+			Protected:      branch.Protected,
+			RequiredChecks: branch.RequiredChecks,
+			Anchor:         anchor,
+			Commits:        commits,
+		})
+	}
+
+	slices.SortFunc(plan.Branches, func(a, b BranchPlan) int { return strings.Compare(a.Branch, b.Branch) })
+	slices.SortFunc(plan.Skipped, func(a, b SkippedBranch) int { return strings.Compare(a.Branch, b.Branch) })
+
+	return plan
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildRollForwardPlan(t *testing.T) {
+
+	m1, m2 := strings.Repeat("1", 40), strings.Repeat("2", 40)
+	commitsGraph := map[string]*HeadCommit{
+		m1: {SHA: m1, GitOpsCommits: map[string]GitOpsCommit{}},
+		m2: {SHA: m2, GitOpsCommits: map[string]GitOpsCommit{}},
+	}
+	matcher, err := NewReferenceMatcher(nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create the matcher: %v", err)
+	}
+
+	// Histories are newest to oldest
+	commitsHistory := map[string][]GitOpsCommit{
+		"gitops/api": {
+			{SHA: "a4", Message: "Revert \"Deploy trivago/web@" + m2[:7] + "\"\n\nRollback-Id: 20250109T103015Z-1111111"},
+			{SHA: "a3", Message: "Tune the replicas"},
+			{SHA: "a2", Message: "Deploy trivago/web@" + m2[:7]},
+			{SHA: "a1", Message: "Deploy trivago/web@" + m1[:7]},
+		},
+		"gitops/worker": {
+			{SHA: "w3", Message: "Revert \"Revert \\\"Deploy\\\"\"\n\nRoll-Forward-Of: 20250109T103015Z-1111111"},
+			{SHA: "w2", Message: "Revert \"Deploy\"\n\nRollback-Id: 20250109T103015Z-1111111"},
+			{SHA: "w1", Message: "Deploy trivago/web@" + m2[:7]},
+		},
+		"gitops/web": {
+			{SHA: "b3", Message: "Restore manifests to 0123456789ab\n\nRollback-Id: 20250108T090000Z-1111111"},
+			{SHA: "b2", Message: "Deploy trivago/web@" + m2[:7]},
+		},
+	}
+	branches := []GitOpsBranch{{Name: "gitops/api", Head: "a4"}, {Name: "gitops/web", Head: "b3"}, {Name: "gitops/worker", Head: "w3"}}
+
+	if got := latestRollback(commitsHistory); got != "20250109T103015Z-1111111" {
+		t.Errorf("Expected the latest rollback to be the one of gitops/api, got %q", got)
+	}
+
+	plan := buildRollForwardPlan("trivago", "gitops", []string{"manifests"}, Window{}, "20250109T103015Z-1111111", branches, commitsGraph, commitsHistory, matcher)
+
+	if len(plan.Branches) != 1 || plan.Branches[0].Branch != "gitops/api" {
+		t.Fatalf("Expected only gitops/api to be rolled forward, got %+v", plan.Branches)
+	}
+	api := plan.Branches[0]
+	if api.Anchor != (RollbackCommit{GitOpsCommit: "a3", HeadCommit: m2}) {
+		t.Errorf("Expected the anchor to be the commit right before the rollback, deploying %s, got %+v", m2, api.Anchor)
+	}
+	if !slices.Equal(commitSHAs(api.Commits), []string{"a4"}) {
+		t.Errorf("Expected the rollback commit to be reverted, got %v", commitSHAs(api.Commits))
+	}
+
+	skipped := make([]string, 0)
+	for _, branch := range plan.Skipped {
+		skipped = append(skipped, branch.Branch+": "+branch.Reason)
+	}
+	want := []string{
+		"gitops/web: no commit of rollback 20250109T103015Z-1111111 found in the history of the whole history",
+		"gitops/worker: rollback 20250109T103015Z-1111111 was already rolled forward",
+	}
+	if !slices.Equal(skipped, want) {
+		t.Errorf("Unexpected skipped branches:\n%v\nwant:\n%v", skipped, want)
+	}
+
+	if got := planTrailer(plan); got != "Roll-Forward-Of: 20250109T103015Z-1111111" {
+		t.Errorf("Unexpected trailer of the roll-forward commits: %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-github/v71/github"
+)
+
+// CommitSource lists the commits of a branch in a lookback window, optionally restricted to a path,
+// and the files a commit changed. Commits are returned newest first, in the shape of the GitHub commits API.
+type CommitSource interface {
+	ListCommitsSince(ctx context.Context, window Window, branch string) ([]*github.RepositoryCommit, error)
+	ListCommitsSinceOnPath(ctx context.Context, window Window, branch, path string) ([]*github.RepositoryCommit, error)
+	ListCommitFiles(ctx context.Context, sha string) ([]string, error)
+}
+
+// BranchSource lists the branches of a repository that match a filter, and their protection.
+// A nil protected lists protected and unprotected branches.
+type BranchSource interface {
+	ListBranches(ctx context.Context, filter func(string) bool, protected *bool) ([]*github.Branch, error)
+	RequiredChecks(ctx context.Context, branch string) ([]string, error)
+}
+
+// TreeSource lists the paths of the files and directories at the head of a branch
+type TreeSource interface {
+	ListPaths(ctx context.Context, branch string) ([]string, error)
+}
+
+// RefSource resolves the references selecting a rollback target to master commits
+type RefSource interface {
+	ResolveCommit(ctx context.Context, ref string) (string, error)
+	PullRequestMergeCommit(ctx context.Context, number int) (string, error)
+	ReleaseCommit(ctx context.Context, release string) (string, error)
+}
+
+// Source is an SCM backend that can serve the whole analysis
+type Source interface {
+	CommitSource
+	BranchSource
+	TreeSource
+	RefSource
+}
+
+var (
+	_ Source = (*GithubClient)(nil)
+	_ Source = (*LocalClient)(nil)
+)
@@ -3,18 +3,60 @@ package main
 import "time"
 
 type HeadCommit struct {
-	SHA           string
-	Parent        string
-	Date          time.Time
-	GitOpsCommits map[string]GitOpsCommit
+	SHA           string                  `json:"sha" yaml:"sha"`
+	Parents       []string                `json:"parents" yaml:"parents"`
+	Date          time.Time               `json:"date" yaml:"date"`
+	GitOpsCommits map[string]GitOpsCommit `json:"gitopsCommits" yaml:"gitopsCommits"`
 }
 
 type GitOpsCommit struct {
-	SHA  string
-	Date time.Time
+	SHA     string    `json:"sha" yaml:"sha"`
+	Date    time.Time `json:"date" yaml:"date"`
+	Author  string    `json:"author,omitempty" yaml:"author,omitempty"`
+	Message string    `json:"message,omitempty" yaml:"message,omitempty"`
+	// Paths analyzed that the commit touched
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// LeftAlone are the files outside of the paths that the commit changed, a path-scoped revert doesn't undo them
+	LeftAlone []string `json:"leftAlone,omitempty" yaml:"leftAlone,omitempty"`
 }
 
 type RollbackCommit struct {
-	GitOpsCommit string
-	HeadCommit   string
+	GitOpsCommit string `json:"gitopsCommit" yaml:"gitopsCommit"`
+	HeadCommit   string `json:"headCommit" yaml:"headCommit"`
+}
+
+// AnchorResult is the outcome of the search of an anchor for every gitops branch
+type AnchorResult struct {
+	// Anchors are the newest deploys of the target or one of its ancestors
+	Anchors map[string]RollbackCommit
+	// NeverDeployed are the branches without any deploy of a master commit in the fetched history
+	NeverDeployed []string
+	// DeployedAfterTarget are the branches whose deploys in the fetched history are all newer than the target,
+	// with their oldest visible deploy
+	DeployedAfterTarget map[string]RollbackCommit
+	// DeployedNonAncestors are the branches with a deploy older than the target in the fetched history,
+	// but only of commits that aren't ancestors of the target, with their oldest visible deploy
+	DeployedNonAncestors map[string]RollbackCommit
+}
+
+type GitOpsBranch struct {
+	Name           string   `json:"name" yaml:"name"`
+	Head           string   `json:"head" yaml:"head"`
+	Protected      *bool    `json:"protected,omitempty" yaml:"protected,omitempty"`
+	RequiredChecks []string `json:"requiredChecks,omitempty" yaml:"requiredChecks,omitempty"`
+}
+
+type ExcludedBranch struct {
+	Branch string `json:"branch" yaml:"branch"`
+	Reason string `json:"reason" yaml:"reason"`
+	// candidate is set when the branch matched the prefix or include patterns
+	candidate bool
+}
+
+type BranchStatus struct {
+	Branch         string    `json:"branch" yaml:"branch"`
+	Head           string    `json:"head" yaml:"head"`
+	GitOpsCommit   string    `json:"gitopsCommit,omitempty" yaml:"gitopsCommit,omitempty"`
+	DeployedCommit string    `json:"deployedCommit,omitempty" yaml:"deployedCommit,omitempty"`
+	DeployedAt     time.Time `json:"deployedAt,omitempty" yaml:"deployedAt,omitempty"`
 }
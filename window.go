@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// windowTimeLayouts are the absolute times accepted by a lookback window, the date alone is in local time
+var windowTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+// Window is the lookback window of a history, either everything since a time or the last commits of a branch
+type Window struct {
+	Spec    string     `json:"spec" yaml:"spec"`
+	Since   *time.Time `json:"since,omitempty" yaml:"since,omitempty"`
+	Commits int        `json:"commits,omitempty" yaml:"commits,omitempty"`
+}
+
+// sinceWindow returns the window of everything since a time
+func sinceWindow(since time.Time) Window {
+	return Window{Spec: since.Format(time.RFC3339), Since: &since}
+}
+
+// parseWindow parses a lookback window relative to now, either a number of months (3), a duration (72h),
+// a date (2026-10-01) or RFC3339 timestamp, or a number of commits (300commits)
+func parseWindow(spec string, now time.Time) (Window, error) {
+
+	spec = strings.TrimSpace(spec)
+	window := Window{Spec: spec}
+
+	if spec == "" {
+		return Window{}, fmt.Errorf("empty lookback window")
+	}
+
+	if months, err := strconv.Atoi(spec); err == nil {
+		if months <= 0 {
+			return Window{}, fmt.Errorf("invalid lookback window %q, the number of months must be positive", spec)
+		}
+		since := now.AddDate(0, -months, 0)
+		window.Since = &since
+		return window, nil
+	}
+
+	if count, ok := strings.CutSuffix(spec, "commits"); ok {
+		commits, err := strconv.Atoi(count)
+		if err != nil || commits <= 0 {
+			return Window{}, fmt.Errorf("invalid lookback window %q, the number of commits must be positive", spec)
+		}
+		window.Commits = commits
+		return window, nil
+	}
+
+	if duration, err := time.ParseDuration(spec); err == nil {
+		if duration <= 0 {
+			return Window{}, fmt.Errorf("invalid lookback window %q, the duration must be positive", spec)
+		}
+		since := now.Add(-duration)
+		window.Since = &since
+		return window, nil
+	}
+
+	for _, layout := range windowTimeLayouts {
+		since, err := time.ParseInLocation(layout, spec, time.Local)
+		if err != nil {
+			continue
+		}
+		if since.After(now) {
+			return Window{}, fmt.Errorf("invalid lookback window %q, the time is in the future", spec)
+		}
+		window.Since = &since
+		return window, nil
+	}
+
+	return Window{}, fmt.Errorf("invalid lookback window %q, expected a number of months (3), a duration (72h), a date (2026-10-01), an RFC3339 timestamp or a number of commits (300commits)", spec)
+}
+
+// widen doubles the window, without going past the limit.
+// It reports false if the window can't be widened anymore.
+func (w Window) widen(now time.Time, limit Window) (Window, bool) {
+
+	if w.Commits > 0 {
+		commits := min(2*w.Commits, limit.Commits)
+		if commits <= w.Commits {
+			return w, false
+		}
+		return Window{Spec: fmt.Sprintf("%dcommits", commits), Commits: commits}, true
+	}
+
+	if w.Since == nil || limit.Since == nil {
+		return w, false
+	}
+
+	since := now.Add(-2 * now.Sub(*w.Since))
+	if since.Before(*limit.Since) {
+		since = *limit.Since
+	}
+	if !since.Before(*w.Since) {
+		return w, false
+	}
+
+	return sinceWindow(since), true
+}
+
+// defaultMaxWindow returns the limit of the adaptive fetching when none is given,
+// 12 months for a time window or 10 times the commits of a commit window
+func defaultMaxWindow(w Window, now time.Time) Window {
+
+	if w.Commits > 0 {
+		return Window{Spec: fmt.Sprintf("%dcommits", 10*w.Commits), Commits: 10 * w.Commits}
+	}
+
+	since := now.AddDate(0, -12, 0)
+	return Window{Spec: "12", Since: &since}
+}
+
+// full reports whether a history of n commits fills the window
+func (w Window) full(n int) bool {
+	return w.Commits > 0 && n >= w.Commits
+}
+
+func (w Window) String() string {
+
+	if w.Commits > 0 {
+		return fmt.Sprintf("of the last %d commits", w.Commits)
+	}
+
+	if w.Since != nil {
+		return fmt.Sprintf("since %s", w.Since.Format(time.RFC3339))
+	}
+
+	return "of the whole history"
+}
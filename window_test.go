@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec    string
+		since   time.Time
+		commits int
+		wantErr bool
+	}{
+		{spec: "1", since: time.Date(2026, 9, 16, 12, 0, 0, 0, time.UTC)},
+		{spec: "72h", since: time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)},
+		{spec: "2026-10-01T08:30:00Z", since: time.Date(2026, 10, 1, 8, 30, 0, 0, time.UTC)},
+		{spec: "2026-10-15T14:05Z", since: time.Date(2026, 10, 15, 14, 5, 0, 0, time.UTC)},
+		{spec: "2026-10-01", since: time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)},
+		{spec: "300commits", commits: 300},
+		{spec: "0", wantErr: true},
+		{spec: "-24h", wantErr: true},
+		{spec: "0commits", wantErr: true},
+		{spec: "2027-01-01", wantErr: true},
+		{spec: "last week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		window, err := parseWindow(tt.spec, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseWindow(%q) expected an error, got %v", tt.spec, window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWindow(%q) failed: %v", tt.spec, err)
+			continue
+		}
+
+		if window.Commits != tt.commits {
+			t.Errorf("parseWindow(%q) commits = %d, want %d", tt.spec, window.Commits, tt.commits)
+		}
+		if tt.commits == 0 && (window.Since == nil || !window.Since.Equal(tt.since)) {
+			t.Errorf("parseWindow(%q) since = %v, want %v", tt.spec, window.Since, tt.since)
+		}
+	}
+}
+
+func TestWindowWiden(t *testing.T) {
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	window, err := parseWindow("72h", now)
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	limit, err := parseWindow("240h", now)
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+
+	wantSince := []time.Time{now.Add(-144 * time.Hour), now.Add(-240 * time.Hour)}
+	for _, want := range wantSince {
+		var ok bool
+		window, ok = window.widen(now, limit)
+		if !ok || !window.Since.Equal(want) {
+			t.Fatalf("Expected the window to widen to %v, got %v (%v)", want, window.Since, ok)
+		}
+	}
+
+	if _, ok := window.widen(now, limit); ok {
+		t.Errorf("Expected the window not to widen past the limit")
+	}
+
+	commits, ok := Window{Commits: 300}.widen(now, Window{Commits: 1000})
+	if !ok || commits.Commits != 600 {
+		t.Errorf("Expected the commit window to double, got %d", commits.Commits)
+	}
+
+	if _, ok := (Window{Commits: 300}).widen(now, limit); ok {
+		t.Errorf("Expected a commit window not to widen with a time limit")
+	}
+}